@@ -4,10 +4,15 @@
 package paginate
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/assert"
@@ -16,7 +21,7 @@ import (
 )
 
 func TestOrderByClause(t *testing.T) {
-	ob, err := orderBy(
+	ob, _, err := orderBy(
 		&Config{
 			OrderableCols: []string{"id", "date"},
 		},
@@ -26,7 +31,7 @@ func TestOrderByClause(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "id asc, date", ob)
 
-	ob, err = orderBy(
+	ob, _, err = orderBy(
 		&Config{
 			OrderableCols: []string{"ID", "date"},
 		},
@@ -37,7 +42,7 @@ func TestOrderByClause(t *testing.T) {
 	assert.Equal(t, "id desc", ob)
 
 	// Invalid sort direction
-	ob, err = orderBy(
+	ob, _, err = orderBy(
 		&Config{
 			OrderableCols: []string{"ID", "date"},
 		},
@@ -48,7 +53,7 @@ func TestOrderByClause(t *testing.T) {
 	assert.Empty(t, ob)
 
 	// Invalid field 'user_id'
-	ob, err = orderBy(
+	ob, _, err = orderBy(
 		&Config{
 			OrderableCols: []string{"ID", "date"},
 		},
@@ -59,7 +64,7 @@ func TestOrderByClause(t *testing.T) {
 	assert.Empty(t, ob)
 
 	// Too many fields.
-	ob, err = orderBy(
+	ob, _, err = orderBy(
 		&Config{
 			OrderableCols: []string{"ID", "date"},
 		},
@@ -70,7 +75,7 @@ func TestOrderByClause(t *testing.T) {
 	assert.Empty(t, ob)
 
 	// Cannot order by anything.
-	ob, err = orderBy(
+	ob, _, err = orderBy(
 		&Config{},
 		&Query{
 			OrderBy: []string{"id"},
@@ -79,6 +84,407 @@ func TestOrderByClause(t *testing.T) {
 	assert.Empty(t, ob)
 }
 
+func TestOrderByNullsClause(t *testing.T) {
+	c := &Config{
+		OrderableCols: []string{"id", "last_login", "age"},
+		NullsOrder:    map[string]NullsOrder{"Last_Login": NullsLast},
+		dialect:       "postgres",
+	}
+	q := &Query{
+		OrderBy: []string{"id DESC NULLS FIRST", "last_login", "age nulls last"},
+	}
+	ob, _, err := orderBy(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "id desc nulls first, last_login nulls last, age nulls last", ob)
+
+	// Emulated elsewhere.
+	c.dialect = "mysql"
+	ob, _, err = orderBy(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "id is null desc, id desc, last_login is null, last_login, age is null, age", ob)
+
+	c.dialect = "mssql"
+	ob, _, err = orderBy(c, &Query{OrderBy: []string{"age asc nulls first"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "case when age is null then 1 else 0 end desc, age asc", ob)
+
+	// The query overrides the config.
+	ob, _, err = orderBy(c, &Query{OrderBy: []string{"last_login desc nulls first"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "case when last_login is null then 1 else 0 end desc, last_login desc", ob)
+
+	for _, o := range []string{"id nulls", "id nulls middle", "id asc nulls first please", "id up nulls first", "id asc first"} {
+		_, _, err = orderBy(c, &Query{OrderBy: []string{o}})
+		assert.Error(t, err, o)
+	}
+}
+
+func TestDefaultOrderByClause(t *testing.T) {
+	c := &Config{
+		OrderableCols:  []string{"age", "id"},
+		DefaultOrderBy: []string{"name desc"},
+		TiebreakerCol:  "ID",
+	}
+	ob, _, err := orderBy(c, &Query{OrderBy: []string{" "}})
+	assert.NoError(t, err)
+	assert.Equal(t, "name desc, id", ob)
+
+	ob, _, err = orderBy(c, &Query{OrderBy: []string{"age"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "age, id", ob)
+
+	// Already unique.
+	ob, _, err = orderBy(c, &Query{OrderBy: []string{"id desc", "age"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "id desc, age", ob)
+
+	// The default order is not a license to order by anything.
+	_, _, err = orderBy(c, &Query{OrderBy: []string{"name"}})
+	assert.Error(t, err)
+
+	c.DefaultOrderBy = nil
+	ob, _, err = orderBy(c, &Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, "id", ob)
+}
+
+func TestOrderBySyntaxes(t *testing.T) {
+	c := &Config{
+		OrderableCols: []string{"created_at", "name", "age"},
+	}
+	q := &Query{OrderBy: []string{"-created_at, +name", "age:asc"}}
+
+	// Not accepted by default.
+	_, _, err := orderBy(c, q)
+	assert.Error(t, err)
+
+	c.OrderSyntaxes = OrderJSONAPI | OrderColon
+	ob, _, err := orderBy(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "created_at desc, name asc, age asc", ob)
+
+	ob, _, err = orderBy(c, &Query{OrderBy: []string{"-age nulls last,name:DESC NULLS FIRST,,"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "age is null, age desc, name is null desc, name desc", ob)
+
+	// Only the enabled syntaxes are accepted.
+	c.OrderSyntaxes = OrderSigned
+	ob, _, err = orderBy(c, &Query{OrderBy: []string{"-created_at", "+name"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "created_at desc, name asc", ob)
+	for _, o := range []string{"-age,name", "age:desc", "-age asc", "-"} {
+		_, _, err = orderBy(c, &Query{OrderBy: []string{o}})
+		assert.Error(t, err, o)
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	c := &Config{
+		Expressions: map[string]string{
+			"full_name": "first_name || ' ' || last_name",
+			"Email":     "LOWER(email)",
+		},
+		SelectableCols: []string{"id", "full_name"},
+		Where:          map[string]string{"full_name": "like ?", "email": "= ?"},
+		OrderableCols:  []string{"full_name", "email"},
+		TiebreakerCol:  "id",
+	}
+	q := &Query{
+		WhereArgs: map[string]interface{}{"email": "bob@example.com", "full_name": "bob%"},
+		OrderBy:   []string{"full_name desc", "EMAIL"},
+	}
+
+	s, err := selectCols(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "id, first_name || ' ' || last_name AS full_name", s)
+	s, err = selectCols(c, &Query{Select: []string{"Full_Name"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "first_name || ' ' || last_name AS full_name", s)
+
+	w, wa, err := where(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "(LOWER(email)) = ? AND (first_name || ' ' || last_name) like ?", w)
+	assert.Equal(t, []interface{}{"bob@example.com", "bob%"}, wa)
+
+	ob, _, err := orderBy(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "(first_name || ' ' || last_name) desc, (LOWER(email)), id", ob)
+
+	// Expressions are not orderable unless whitelisted.
+	c.OrderableCols = nil
+	_, _, err = orderBy(c, q)
+	assert.Error(t, err)
+}
+
+func TestAliases(t *testing.T) {
+	c := &Config{
+		Aliases: map[string]string{
+			"createdAt": "created_at",
+			"customer":  "customer_id",
+			"fullName":  "full_name",
+		},
+		Expressions:    map[string]string{"full_name": "first_name || ' ' || last_name"},
+		SelectableCols: []string{"id", "created_at", "full_name"},
+		Where:          map[string]string{"customer_id": "= ?", "created_at": "> ?"},
+		OrderableCols:  []string{"created_at"},
+		NullsOrder:     map[string]NullsOrder{"created_at": NullsLast},
+		TiebreakerCol:  "id",
+		dialect:        "postgres",
+	}
+	q := &Query{
+		Select:    []string{"id", "createdAt", "fullname"},
+		WhereArgs: map[string]interface{}{"Customer": 7, "createdAt": "2019-01-01"},
+		OrderBy:   []string{"createdAt desc"},
+	}
+
+	s, err := selectCols(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "id, created_at, first_name || ' ' || last_name AS full_name", s)
+	w, wa, err := where(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "created_at > ? AND customer_id = ?", w)
+	assert.Equal(t, []interface{}{"2019-01-01", 7}, wa)
+	ob, _, err := orderBy(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "created_at desc nulls last, id", ob)
+
+	// Errors speak the public names.
+	c.SelectableCols = []string{"id"}
+	c.Where = nil
+	c.OrderableCols = nil
+	_, err = selectCols(c, q)
+	assert.EqualError(t, err, `query cannot select column "createdat"`)
+	_, _, err = where(c, q)
+	assert.EqualError(t, err, `where argument "createdat" not allowed`)
+	_, _, err = orderBy(c, q)
+	assert.EqualError(t, err, `query cannot order by field "createdAt desc"`)
+}
+
+func TestHiddenCols(t *testing.T) {
+	c := &Config{
+		SelectableCols:      []string{"id", "name", "bio", "password_hash"},
+		Where:               map[string]string{"name": "LIKE ?", "password_hash": "LIKE ?"},
+		OrderableCols:       []string{"name", "password_hash"},
+		HiddenCols:          []string{"password_hash"},
+		DefaultExcludedCols: []string{"bio"},
+	}
+
+	// Default selection leaves out hidden and excluded columns.
+	s, err := selectCols(c, &Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, "id, name", s)
+
+	// Excluded columns can still be asked for.
+	s, err = selectCols(c, &Query{Select: []string{"id", "bio"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "id, bio", s)
+
+	// Hidden columns cannot.
+	_, err = selectCols(c, &Query{Select: []string{"password_hash"}})
+	assert.EqualError(t, err, `query cannot select column "password_hash"`)
+	_, _, err = where(c, &Query{WhereArgs: map[string]interface{}{"password_hash": "x"}})
+	assert.EqualError(t, err, `where argument "password_hash" not allowed`)
+	_, _, err = orderBy(c, &Query{OrderBy: []string{"password_hash"}})
+	assert.EqualError(t, err, `query cannot order by field "password_hash"`)
+
+	// Nor are they searched.
+	w, wa, err := where(c, &Query{Search: "%x%"})
+	assert.NoError(t, err)
+	assert.Equal(t, "name LIKE ?", w)
+	assert.Equal(t, []interface{}{"%x%"}, wa)
+
+	// Without SelectableCols, excluding columns needs the model's columns.
+	c.SelectableCols = nil
+	_, err = selectCols(c, &Query{})
+	assert.Error(t, err)
+	c.modelCols = []string{"id", "name", "bio", "password_hash"}
+	s, err = selectCols(c, &Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, "id, name", s)
+	s, err = selectCols(c, &Query{Select: []string{"id", "bio"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "id, bio", s)
+
+	// Nor can anything that is not a known column reach them.
+	for _, sel := range []string{"*", "users.password_hash", "password_hash as p", "nickname"} {
+		_, err = selectCols(c, &Query{Select: []string{sel}})
+		assert.EqualError(t, err, `query cannot select column "`+sel+`"`)
+	}
+}
+
+func TestDefaultExcludedCols(t *testing.T) {
+	db, f := setupPets(t)
+	defer f()
+
+	c := Config{
+		HiddenCols:          []string{"owner_id"},
+		DefaultExcludedCols: []string{"species"},
+		DefaultOrderBy:      []string{"id"},
+	}
+	q := Query{Page: 1, PageSize: 2}
+	var pets []pet
+	res, err := Do(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []pet{{ID: 1, Name: "Rex"}, {ID: 2, Name: "Tom"}}, pets)
+
+	q.Select = []string{"name", "species"}
+	pets = nil
+	res, err = Do(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []pet{{Name: "Rex", Species: "dog"}, {Name: "Tom", Species: "cat"}}, pets)
+
+	q.Select = []string{"owner_id"}
+	_, err = Do(db, c, q, &pets)
+	assert.Error(t, err)
+}
+
+func TestComputedColsClause(t *testing.T) {
+	c := &Config{
+		SelectableCols: []string{"id"},
+		ComputedCols: map[string]ComputedCol{
+			"total_with_tax": {Expr: "total * 1.2", Orderable: true},
+			"age_bucket":     {Expr: "age / 10", Where: "= ?"},
+		},
+	}
+
+	// Computed columns are selected by default and may be selected by name.
+	s, err := selectCols(c, &Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, "id, age / 10 AS age_bucket, total * 1.2 AS total_with_tax", s)
+	s, err = selectCols(c, &Query{Select: []string{"Total_With_Tax"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "total * 1.2 AS total_with_tax", s)
+	c.SelectableCols = nil
+	s, err = selectCols(c, &Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, "*, age / 10 AS age_bucket, total * 1.2 AS total_with_tax", s)
+
+	// Filtering and ordering as far as they are allowed.
+	w, wa, err := where(c, &Query{WhereArgs: map[string]interface{}{"age_bucket": 3}})
+	assert.NoError(t, err)
+	assert.Equal(t, "(age / 10) = ?", w)
+	assert.Equal(t, []interface{}{3}, wa)
+	_, _, err = where(c, &Query{WhereArgs: map[string]interface{}{"total_with_tax": 3}})
+	assert.EqualError(t, err, `where argument "total_with_tax" not allowed`)
+	ob, _, err := orderBy(c, &Query{OrderBy: []string{"total_with_tax desc"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "(total * 1.2) desc", ob)
+	_, _, err = orderBy(c, &Query{OrderBy: []string{"age_bucket"}})
+	assert.EqualError(t, err, `query cannot order by field "age_bucket"`)
+}
+
+func TestJSONPathsClause(t *testing.T) {
+	c := &Config{
+		SelectableCols: []string{"id"},
+		JSONPaths:      map[string][]string{"attrs": {"color", "size.Width"}},
+		Where:          map[string]string{"attrs.size.width": "> ?"},
+		Relations:      map[string]Relation{"owner": {Join: "JOIN owners AS owner ON owner.id = pets.owner_id"}},
+		dialect:        "postgres",
+	}
+	q := &Query{
+		Select:    []string{"id", "attrs.color", "attrs.size.width"},
+		WhereArgs: map[string]interface{}{"attrs.color": "red", "attrs.size.width": "10"},
+		OrderBy:   []string{"attrs.size.width desc"},
+	}
+
+	s, err := selectCols(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "id, attrs->>'color' AS attrs_color, attrs#>>'{size,Width}' AS attrs_size_width", s)
+	w, wa, err := where(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "attrs->>'color' = ? AND attrs#>>'{size,Width}' > ?", w)
+	assert.Equal(t, []interface{}{"red", "10"}, wa)
+	ob, _, err := orderBy(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "attrs#>>'{size,Width}' desc", ob)
+
+	for dialect, expr := range map[string]string{
+		"mysql":   "JSON_UNQUOTE(JSON_EXTRACT(attrs, '$.size.Width'))",
+		"sqlite3": "json_extract(attrs, '$.size.Width')",
+		"mssql":   "JSON_VALUE(attrs, '$.size.Width')",
+	} {
+		c.dialect = dialect
+		assert.Equal(t, expr, colExpr(c, "attrs.size.width"), dialect)
+	}
+
+	// Only whitelisted paths of JSON columns.
+	_, err = selectCols(c, &Query{Select: []string{"attrs.shape"}})
+	assert.EqualError(t, err, `query cannot select column "attrs.shape"`)
+	_, _, err = orderBy(c, &Query{OrderBy: []string{"owner.name"}})
+	assert.EqualError(t, err, `query cannot order by field "owner.name"`)
+	c.HiddenCols = []string{"attrs"}
+	_, _, err = where(c, &Query{WhereArgs: map[string]interface{}{"attrs.color": "red"}})
+	assert.EqualError(t, err, `where argument "attrs.color" not allowed`)
+}
+
+func TestGroupClause(t *testing.T) {
+	c := &Config{
+		Aliases:       map[string]string{"state": "status"},
+		GroupableCols: []string{"status", "region"},
+		Aggregates:    map[string][]string{"*": {"count"}, "total": {"sum", "avg"}},
+		TiebreakerCol: "id",
+	}
+	q := &Query{
+		GroupBy:    []string{"State", "region"},
+		Aggregates: []string{"count(*)", "SUM(total)"},
+		OrderBy:    []string{"sum_total desc"},
+	}
+
+	cols, err := groupCols(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"status", "region"}, cols)
+	aggs, err := aggregates(c, q)
+	assert.NoError(t, err)
+	s, err := groupSelect(c, q, cols, aggs)
+	assert.NoError(t, err)
+	assert.Equal(t, "status, region, COUNT(*) AS count, SUM(total) AS sum_total", s)
+	ob, err := groupOrderBy(c, q, cols, aggs)
+	assert.NoError(t, err)
+	assert.Equal(t, "SUM(total) desc, status, region", ob)
+
+	// Only grouped columns can be selected.
+	_, err = groupSelect(c, &Query{Select: []string{"id"}}, cols, aggs)
+	assert.EqualError(t, err, `query cannot select column "id"`)
+	_, err = groupOrderBy(c, &Query{OrderBy: []string{"id"}}, cols, aggs)
+	assert.EqualError(t, err, `query cannot order by field "id"`)
+
+	// Only whitelisted groups and aggregates.
+	_, err = groupCols(c, &Query{GroupBy: []string{"id"}})
+	assert.EqualError(t, err, `query cannot group by column "id"`)
+	for _, a := range []string{"max(total)", "sum(*)", "count(id)", "median(total)"} {
+		_, err = aggregates(c, &Query{Aggregates: []string{a}})
+		assert.EqualError(t, err, `query cannot aggregate "`+a+`"`)
+	}
+}
+
+func TestTruncDate(t *testing.T) {
+	for dialect, expr := range map[string]string{
+		"postgres": "date_trunc('month', created_at)",
+		"mysql":    "DATE_FORMAT(created_at, '%Y-%m-01')",
+		"mssql":    "DATEADD(month, DATEDIFF(month, 0, created_at), 0)",
+		"sqlite3":  "strftime('%Y-%m-01', created_at)",
+	} {
+		assert.Equal(t, expr, truncDate(&Config{dialect: dialect}, "created_at", "month"), dialect)
+	}
+	c := &Config{dialect: "sqlite3"}
+	assert.Equal(t, "date(created_at, 'weekday 0', '-6 days')", truncDate(c, "created_at", "week"))
+	assert.Equal(t, "date(created_at)", truncDate(c, "created_at", "day"))
+}
+
+func TestDistinctSelect(t *testing.T) {
+	c := &Config{Distinct: true, table: "pets"}
+	assert.Equal(t, "DISTINCT pets.*", distinctSelect(c, ""))
+	assert.Equal(t, "DISTINCT pets.name", distinctSelect(c, "pets.name"))
+
+	c.DistinctOn = []string{"species", "owner.name"}
+	c.dialect = "postgres"
+	assert.Equal(t, "DISTINCT ON (pets.species, owner.name) pets.name", distinctSelect(c, "pets.name"))
+	c.dialect = "mysql"
+	assert.Equal(t, "pets.name", distinctSelect(c, "pets.name"))
+}
+
 func TestSelectClause(t *testing.T) {
 	// Empty SelectableCols means "*"
 	s, err := selectCols(
@@ -207,6 +613,163 @@ func TestWhereWithSearch(t *testing.T) {
 	assert.Equal(t, "search term is disallowed by config", err.Error())
 }
 
+func TestRelevanceClause(t *testing.T) {
+	c := &Config{
+		Where: map[string]string{
+			"first_name": "like ?",
+			"last_name":  "like ?",
+			"age":        "> ?",
+		},
+		OrderableCols: []string{"age"},
+		SearchWeights: map[string]float64{"last_name": 2.5},
+	}
+	ob, oa, err := orderBy(c, &Query{
+		OrderBy: []string{"_relevance", "age"},
+		Search:  "%aug%",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "((CASE WHEN LOWER(first_name) = LOWER(?) THEN 3 WHEN LOWER(first_name) LIKE LOWER(?) THEN 2 WHEN LOWER(first_name) LIKE LOWER(?) THEN 1 ELSE 0 END)"+
+		" + (CASE WHEN LOWER(last_name) = LOWER(?) THEN 3 WHEN LOWER(last_name) LIKE LOWER(?) THEN 2 WHEN LOWER(last_name) LIKE LOWER(?) THEN 1 ELSE 0 END) * 2.5) desc, age", ob)
+	assert.Equal(t, []interface{}{"aug", "aug%", "%aug%", "aug", "aug%", "%aug%"}, oa)
+
+	// No search term means nothing to order by.
+	ob, oa, err = orderBy(c, &Query{OrderBy: []string{"_relevance asc"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "", ob)
+	assert.Empty(t, oa)
+
+	// Full-text search uses the engine's rank.
+	c.FullTextSearch = true
+	c.dialect = "postgres"
+	ob, oa, err = orderBy(c, &Query{
+		OrderBy: []string{"_relevance asc"},
+		Search:  "aug",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "(ts_rank(to_tsvector(first_name), plainto_tsquery(?)) + ts_rank(to_tsvector(last_name), plainto_tsquery(?)) * 2.5) asc", ob)
+	assert.Equal(t, []interface{}{"aug", "aug"}, oa)
+	w, wa, err := where(c, &Query{Search: "%aug%"})
+	assert.NoError(t, err)
+	assert.Equal(t, "to_tsvector(first_name) @@ plainto_tsquery(?) OR to_tsvector(last_name) @@ plainto_tsquery(?)", w)
+	assert.Equal(t, []interface{}{"aug", "aug"}, wa)
+
+	// But not all dialects have one.
+	c.dialect = "sqlite3"
+	_, _, err = where(c, &Query{Search: "aug"})
+	assert.Error(t, err)
+}
+
+func TestTokenizedSearch(t *testing.T) {
+	include, exclude := tokenize(` john  "van der" -smith -"de la" - "unclosed`)
+	assert.Equal(t, []string{"john", "van der", "unclosed"}, include)
+	assert.Equal(t, []string{"smith", "de la"}, exclude)
+
+	c := &Config{
+		Where: map[string]string{
+			"first_name": "like ?",
+			"last_name":  "like ?",
+			"age":        "> ?",
+		},
+		TokenizeSearch: true,
+	}
+	w, wa, err := where(c, &Query{
+		WhereArgs: map[string]interface{}{"age": 30},
+		Search:    "john smi% -doe",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "age > ? AND ((first_name like ? OR last_name like ?) AND (first_name like ? OR last_name like ?)"+
+		" AND (first_name IS NULL OR NOT (first_name like ?)) AND (last_name IS NULL OR NOT (last_name like ?)))", w)
+	assert.Equal(t, []interface{}{30, "%john%", "%john%", "smi%", "smi%", "%doe%", "%doe%"}, wa)
+
+	// Too many tokens.
+	c.MaxSearchTokens = 2
+	_, _, err = where(c, &Query{Search: "a b c"})
+	assert.Error(t, err)
+}
+
+func TestSearchCols(t *testing.T) {
+	c := &Config{
+		Where: map[string]string{"status": "not like ?"},
+		SearchCols: []SearchCol{
+			{Name: "first_name", Mode: SearchPrefix, Weight: 2},
+			{Name: "email", Mode: SearchExact},
+			{Name: "bio", Mode: SearchContains},
+		},
+	}
+	// Where entries are only filters, even if they contain LIKE.
+	w, wa, err := where(c, &Query{
+		WhereArgs: map[string]interface{}{"status": "closed%"},
+		Search:    "%Aug%",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "status not like ? AND (LOWER(first_name) LIKE LOWER(?) OR LOWER(email) = LOWER(?) OR LOWER(bio) LIKE LOWER(?))", w)
+	assert.Equal(t, []interface{}{"closed%", "Aug%", "Aug", "%Aug%"}, wa)
+
+	// Without SearchCols, NOT LIKE entries are not searched either.
+	lc := &Config{Where: map[string]string{"status": "NOT  LIKE ?", "name": "like ?"}}
+	w, wa, err = where(lc, &Query{Search: "%Aug%"})
+	assert.NoError(t, err)
+	assert.Equal(t, "name like ?", w)
+	assert.Equal(t, []interface{}{"%Aug%"}, wa)
+	lc.Where = map[string]string{"a": "NOT ILIKE ?", "b": "not like ?", "c": "ILIKE ?", "d": "= ?"}
+	assert.Equal(t, []string{"c"}, likeClauses(lc))
+
+	// Weights are applied per column.
+	c.SearchCols = c.SearchCols[:2]
+	ob, oa, err := orderBy(c, &Query{OrderBy: []string{"_relevance"}, Search: "Aug"})
+	assert.NoError(t, err)
+	assert.Equal(t, "((CASE WHEN LOWER(first_name) = LOWER(?) THEN 3 WHEN LOWER(first_name) LIKE LOWER(?) THEN 2 WHEN LOWER(first_name) LIKE LOWER(?) THEN 1 ELSE 0 END) * 2"+
+		" + (CASE WHEN LOWER(email) = LOWER(?) THEN 3 WHEN LOWER(email) LIKE LOWER(?) THEN 2 WHEN LOWER(email) LIKE LOWER(?) THEN 1 ELSE 0 END)) desc", ob)
+	assert.Equal(t, []interface{}{"Aug", "Aug%", "%Aug%", "Aug", "Aug%", "%Aug%"}, oa)
+
+	// Full-text columns may be mixed with others.
+	c.dialect = "mysql"
+	c.SearchCols = []SearchCol{{Name: "bio", Mode: SearchFullText}, {Name: "email", Mode: SearchPrefix}}
+	w, wa, err = where(c, &Query{Search: "Aug"})
+	assert.NoError(t, err)
+	assert.Equal(t, "MATCH (bio) AGAINST (?) OR LOWER(email) LIKE LOWER(?)", w)
+	assert.Equal(t, []interface{}{"Aug", "Aug%"}, wa)
+
+	// Invalid mode.
+	c.SearchCols = []SearchCol{{Name: "bio", Mode: SearchMode(42)}}
+	_, _, err = where(c, &Query{Search: "Aug"})
+	assert.Error(t, err)
+}
+
+func TestFuzzyClause(t *testing.T) {
+	assert.Equal(t, 0.5, similarity("Potranka", "potranca"))
+	assert.Equal(t, 1.0, similarity("Smart  Guy!", "guy smart"))
+	assert.Equal(t, 0.0, similarity("", "guy"))
+
+	c := &Config{
+		SearchCols:     []SearchCol{{Name: "name", Mode: SearchFuzzy, Weight: 2}},
+		FuzzyThreshold: 0.45,
+		dialect:        "postgres",
+	}
+	q := &Query{
+		OrderBy: []string{"_relevance"},
+		Search:  "%potranca%",
+	}
+	w, wa, err := where(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "similarity(name, ?) >= 0.45", w)
+	assert.Equal(t, []interface{}{"potranca"}, wa)
+	ob, oa, err := orderBy(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "(similarity(name, ?) * 2) desc", ob)
+	assert.Equal(t, []interface{}{"potranca"}, oa)
+
+	// Other databases match in Go.
+	c.dialect = "sqlite3"
+	w, wa, err = where(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "", w)
+	assert.Empty(t, wa)
+	ob, _, err = orderBy(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "", ob)
+}
+
 func TestSimple(t *testing.T) {
 	db, f := setup(t)
 	defer f()
@@ -300,6 +863,64 @@ func TestOrderBy(t *testing.T) {
 	})
 }
 
+func TestOrderByNulls(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		OrderableCols: []string{"age", "iq"},
+		NullsOrder:    map[string]NullsOrder{"iq": NullsFirst},
+	}
+	q := Query{
+		PageSize: 4,
+		Page:     1,
+		OrderBy:  []string{"age asc nulls last", "iq DESC"},
+	}
+
+	testPagination(t, db, c, q, [][]dbModel{
+		{
+			{ID: 5, Name: "Blah", Age: 3, IQ: 100},
+			{ID: 3, Name: "Test Dude", Age: 7, IQ: 200},
+			{ID: 2, Name: "Potranka", Age: 44, IQ: 80},
+			{ID: 7, Name: "Smart Guy", Age: 44, IQ: 30},
+		},
+		{
+			{ID: 1, Name: "Don Jr", Age: 46, IQ: 1},
+			{ID: 4, Name: "Meh", Age: 77, IQ: 120},
+			{ID: 6, Name: "Holliams", Age: 99, IQ: 50},
+		},
+	})
+}
+
+func TestDefaultOrderBy(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		OrderableCols:  []string{"age"},
+		DefaultOrderBy: []string{"age desc"},
+		TiebreakerCol:  "id",
+	}
+	q := Query{
+		PageSize: 4,
+		Page:     1,
+	}
+
+	testPagination(t, db, c, q, [][]dbModel{
+		{
+			{ID: 6, Name: "Holliams", Age: 99, IQ: 50},
+			{ID: 4, Name: "Meh", Age: 77, IQ: 120},
+			{ID: 1, Name: "Don Jr", Age: 46, IQ: 1},
+			{ID: 2, Name: "Potranka", Age: 44, IQ: 80},
+		},
+		{
+			{ID: 7, Name: "Smart Guy", Age: 44, IQ: 30},
+			{ID: 3, Name: "Test Dude", Age: 7, IQ: 200},
+			{ID: 5, Name: "Blah", Age: 3, IQ: 100},
+		},
+	})
+}
+
 func TestWhereAndOrderBy(t *testing.T) {
 	db, f := setup(t)
 	defer f()
@@ -505,32 +1126,1028 @@ func TestSearchAndWhere(t *testing.T) {
 
 }
 
-func TestFilterFunc(t *testing.T) {
+func TestOrderByRelevance(t *testing.T) {
 	db, f := setup(t)
 	defer f()
 
 	c := Config{
-		FilterFunc: func(db *gorm.DB, query Query) *gorm.DB {
-			return db.Where("name NOT LIKE ?", "%dude%")
-		},
+		Where:         map[string]string{"name": "like ?"},
+		OrderableCols: []string{"id"},
 	}
 	q := Query{
-		Page: 1,
+		Page:    1,
+		OrderBy: []string{"_relevance", "id"},
+		Search:  "%h%",
 	}
 
+	// Prefix matches come before matches anywhere in the name.
 	testPagination(t, db, c, q, [][]dbModel{
 		{
-			{ID: 1, Name: "Don Jr", Age: 46, IQ: 1},
-			{ID: 2, Name: "Potranka", Age: 44, IQ: 80},
+			{ID: 6, Name: "Holliams", Age: 99, IQ: 50},
 			{ID: 4, Name: "Meh", Age: 77, IQ: 120},
 			{ID: 5, Name: "Blah", Age: 3, IQ: 100},
-			{ID: 6, Name: "Holliams", Age: 99, IQ: 50},
-			{ID: 7, Name: "Smart Guy", Age: 44, IQ: 30},
 		},
 	})
 }
 
-func TestInvalidQueryPage(t *testing.T) {
+func TestSearchTokens(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		Where:          map[string]string{"name": "like ?"},
+		TokenizeSearch: true,
+	}
+	q := Query{
+		Page:   1,
+		Search: "s -dude",
+	}
+
+	testPagination(t, db, c, q, [][]dbModel{
+		{
+			{ID: 6, Name: "Holliams", Age: 99, IQ: 50},
+			{ID: 7, Name: "Smart Guy", Age: 44, IQ: 30},
+		},
+	})
+
+	q.Search = `"t g" -dude`
+	testPagination(t, db, c, q, [][]dbModel{
+		{
+			{ID: 7, Name: "Smart Guy", Age: 44, IQ: 30},
+		},
+	})
+}
+
+func TestSearchPrefix(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		SearchCols: []SearchCol{{Name: "name", Mode: SearchPrefix}},
+	}
+	q := Query{
+		Page:   1,
+		Search: "s",
+	}
+
+	testPagination(t, db, c, q, [][]dbModel{
+		{
+			{ID: 7, Name: "Smart Guy", Age: 44, IQ: 30},
+		},
+	})
+}
+
+func TestHighlight(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		SearchCols: []SearchCol{{Name: "name"}},
+	}
+	q := Query{
+		Page:   1,
+		Search: "%H%",
+	}
+	var results []dbModel
+	res, err := Do(db, c, q, &results)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+
+	m, err := Highlight(db, c, q, &results)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]Match{
+		{{Col: "name", Ranges: [][2]int{{2, 3}}}},
+		{{Col: "name", Ranges: [][2]int{{3, 4}}}},
+		{{Col: "name", Ranges: [][2]int{{0, 1}}}},
+	}, m)
+
+	// Tokens are highlighted separately. Exclusions never match.
+	c = Config{
+		SearchCols:     []SearchCol{{Name: "name", Mode: SearchPrefix}},
+		TokenizeSearch: true,
+	}
+	q.Search = "sm -dude"
+	m, err = Highlight(db, c, q, []dbModel{testData[2], testData[6]})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]Match{
+		nil,
+		{{Col: "name", Ranges: [][2]int{{0, 2}}}},
+	}, m)
+
+	// NULL columns have nothing to highlight.
+	type person struct {
+		ID       int64
+		Nickname *string
+	}
+	nick := "Smitty"
+	c.SearchCols = []SearchCol{{Name: "nickname"}}
+	q.Search = "sm"
+	m, err = Highlight(db, c, q, []person{{ID: 1}, {ID: 2, Nickname: &nick}})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]Match{
+		nil,
+		{{Col: "nickname", Ranges: [][2]int{{0, 2}}}},
+	}, m)
+
+	// Unknown columns.
+	_, err = Highlight(db, c, q, results)
+	assert.Error(t, err)
+
+	// JSON paths are read from the column they are selected as.
+	type sized struct {
+		ID             int64
+		AttrsSizeWidth string
+	}
+	c = Config{
+		JSONPaths:  map[string][]string{"attrs": {"size.width"}},
+		SearchCols: []SearchCol{{Name: "attrs.size.width"}},
+	}
+	q.Search = "10"
+	m, err = Highlight(db, c, q, []sized{{ID: 1, AttrsSizeWidth: "10cm"}})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]Match{{{Col: "attrs.size.width", Ranges: [][2]int{{0, 2}}}}}, m)
+
+	// Headlines are for Postgres.
+	c = Config{
+		SearchCols: []SearchCol{{Name: "name"}, {Name: "bio", Mode: SearchFullText}},
+		Headlines:  true,
+		dialect:    "postgres",
+	}
+	h, ha, err := headlines(&c, &Query{Search: "bob"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ts_headline(name, plainto_tsquery(?)) AS name_headline, ts_headline(bio, plainto_tsquery(?)) AS bio_headline", h)
+	assert.Equal(t, []interface{}{"bob", "bob"}, ha)
+	_, err = Do(db, c, q, &results)
+	assert.Error(t, err)
+}
+
+func TestFuzzySearchNull(t *testing.T) {
+	db, f := createDB()
+	defer f()
+
+	type person struct {
+		ID       int64
+		Nickname *string
+	}
+	assert.NoError(t, db.AutoMigrate(&person{}).Error)
+	nick := "Smitty"
+	for _, p := range []person{{ID: 1}, {ID: 2, Nickname: &nick}} {
+		assert.NoError(t, db.Create(&p).Error)
+	}
+
+	c := Config{SearchCols: []SearchCol{{Name: "nickname", Mode: SearchFuzzy}}}
+	var people []person
+	res, err := Do(db, c, Query{Page: 1, Search: "smity"}, &people)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []person{{ID: 2, Nickname: &nick}}, people)
+}
+
+func TestFuzzySearch(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		Where:      map[string]string{"age": "> ?"},
+		SearchCols: []SearchCol{{Name: "name", Mode: SearchFuzzy}},
+	}
+	q := Query{
+		Page:     1,
+		PageSize: 1,
+		Search:   "Smart Dude",
+	}
+
+	testPagination(t, db, c, q, [][]dbModel{
+		{
+			{ID: 3, Name: "Test Dude", Age: 7, IQ: 200},
+		},
+		{
+			{ID: 7, Name: "Smart Guy", Age: 44, IQ: 30},
+		},
+	})
+
+	// Most similar first.
+	q.OrderBy = []string{"_relevance"}
+	testPagination(t, db, c, q, [][]dbModel{
+		{
+			{ID: 7, Name: "Smart Guy", Age: 44, IQ: 30},
+		},
+		{
+			{ID: 3, Name: "Test Dude", Age: 7, IQ: 200},
+		},
+	})
+
+	// Other filters still apply.
+	q.WhereArgs = map[string]interface{}{"age": 10}
+	testPagination(t, db, c, q, [][]dbModel{
+		{
+			{ID: 7, Name: "Smart Guy", Age: 44, IQ: 30},
+		},
+	})
+
+	// Stricter threshold.
+	c.FuzzyThreshold = 0.9
+	testPagination(t, db, c, q, nil)
+
+	// Misspelled tokens.
+	c = Config{
+		SearchCols:     []SearchCol{{Name: "name", Mode: SearchFuzzy}},
+		TokenizeSearch: true,
+	}
+	q = Query{
+		Page:   1,
+		Search: "potranca -potranka",
+	}
+	testPagination(t, db, c, q, nil)
+	q.Search = "potranca"
+	testPagination(t, db, c, q, [][]dbModel{
+		{
+			{ID: 2, Name: "Potranka", Age: 44, IQ: 80},
+		},
+	})
+
+	// Too many tokens.
+	c.MaxSearchTokens = 1
+	q.Search = "rex tom kit"
+	var results []dbModel
+	_, err := Do(db, c, q, &results)
+	assert.EqualError(t, err, "search term has 3 tokens, the maximum is 1")
+
+	// Failed matches leave the results alone.
+	c = Config{SearchCols: []SearchCol{{Name: "nickname", Mode: SearchFuzzy}}}
+	q = Query{Page: 1, Search: "dude"}
+	_, err = Do(db, c, q, &results)
+	assert.EqualError(t, err, `results have no field for search column "nickname"`)
+	assert.Nil(t, results)
+}
+
+func TestOrderByExpression(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		Expressions:    map[string]string{"smarts": "iq - age"},
+		SelectableCols: []string{"name", "smarts"},
+		Where:          map[string]string{"smarts": "> ?"},
+		OrderableCols:  []string{"smarts"},
+	}
+	q := Query{
+		Page:      1,
+		Select:    []string{"name"},
+		WhereArgs: map[string]interface{}{"smarts": 0},
+		OrderBy:   []string{"smarts desc"},
+	}
+
+	testPagination(t, db, c, q, [][]dbModel{
+		{
+			{Name: "Test Dude"},
+			{Name: "Blah"},
+			{Name: "Meh"},
+			{Name: "Potranka"},
+		},
+	})
+}
+
+func TestComputedCols(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		ComputedCols: map[string]ComputedCol{
+			"months": {Expr: "age * 12", Where: "> ?", Orderable: true},
+		},
+	}
+	q := Query{
+		Page:      1,
+		WhereArgs: map[string]interface{}{"months": 600},
+		OrderBy:   []string{"months desc"},
+	}
+	type row struct {
+		Name   string
+		Months int
+	}
+	var rows []row
+	res, err := Do(db.Table("db_models"), c, q, &rows)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []row{
+		{Name: "Holliams", Months: 1188},
+		{Name: "Meh", Months: 924},
+	}, rows)
+}
+
+func TestGroupBy(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		GroupableCols:  []string{"age"},
+		Aggregates:     map[string][]string{"*": {"count"}, "iq": {"sum"}},
+		DefaultOrderBy: []string{"id"},
+		TiebreakerCol:  "id",
+	}
+	q := Query{
+		Page:       1,
+		PageSize:   2,
+		GroupBy:    []string{"age"},
+		Aggregates: []string{"count(*)", "sum(iq)"},
+		OrderBy:    []string{"count desc"},
+	}
+	type row struct {
+		Age   int
+		Count int
+		SumIQ int `gorm:"column:sum_iq"`
+	}
+	for _, want := range [][]row{
+		{{Age: 44, Count: 2, SumIQ: 110}, {Age: 3, Count: 1, SumIQ: 100}},
+		{{Age: 7, Count: 1, SumIQ: 200}, {Age: 46, Count: 1, SumIQ: 1}},
+	} {
+		var rows []row
+		res, err := Do(db.Table("db_models"), c, q, &rows)
+		assert.NoError(t, err)
+		assert.NoError(t, res.Error)
+		assert.Equal(t, want, rows)
+		q.Page++
+	}
+
+	// Everything aggregated into one row.
+	var total []row
+	res, err := Do(db.Table("db_models"), c, Query{Page: 1, Aggregates: []string{"count"}}, &total)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []row{{Count: 7}}, total)
+}
+
+func TestFacets(t *testing.T) {
+	db, f := setupPets(t)
+	defer f()
+
+	c := Config{
+		Where:     map[string]string{"species": "= ?", "owner_id": "= ?"},
+		FacetCols: []string{"species", "owner_id"},
+	}
+	q := Query{
+		Page:      1,
+		WhereArgs: map[string]interface{}{"species": "cat"},
+		Facets:    []string{"species", "Owner_ID"},
+	}
+	var pets []pet
+	res, m, err := DoWithMeta(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Len(t, pets, 2)
+	assert.Equal(t, map[string][]FacetCount{
+		"species":  {{"cat", 2}},
+		"owner_id": {{int64(1), 1}, {int64(2), 1}},
+	}, m.Facets)
+
+	// Facets ignore their own filter.
+	c.FacetsExcludeOwnFilter = true
+	c.MaxFacetValues = 1
+	_, m, err = DoWithMeta(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]FacetCount{
+		"species":  {{"cat", 2}},
+		"owner_id": {{int64(1), 1}},
+	}, m.Facets)
+	c.MaxFacetValues = 0
+	_, m, err = DoWithMeta(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.Equal(t, []FacetCount{{"cat", 2}, {"dog", 1}}, m.Facets["species"])
+
+	// The FilterFunc gets the caller's query.
+	var filtered []Query
+	c.FilterFunc = func(db *gorm.DB, fq Query) *gorm.DB {
+		filtered = append(filtered, fq)
+		return db
+	}
+	q.OrderBy = []string{"name"}
+	q.CountTotal = true
+	c.OrderableCols = []string{"name"}
+	_, _, err = DoWithMeta(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.Len(t, filtered, 4)
+	for _, fq := range filtered {
+		assert.Equal(t, q, fq)
+	}
+
+	q.Facets = []string{"name"}
+	_, _, err = DoWithMeta(db, c, q, &pets)
+	assert.EqualError(t, err, `query cannot count facet "name"`)
+}
+
+func TestHistograms(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		Where:         map[string]string{"iq": "> ?"},
+		HistogramCols: []string{"age"},
+	}
+	q := Query{
+		Page:       1,
+		Histograms: []Histogram{{Col: "age", Interval: 25}},
+	}
+	var models []dbModel
+	res, m, err := DoWithMeta(db, c, q, &models)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, [][]Bucket{{{int64(0), 2}, {int64(25), 3}, {int64(75), 2}}}, m.Histograms)
+
+	q.WhereArgs = map[string]interface{}{"iq": 60}
+	c.MaxHistogramBuckets = 2
+	_, m, err = DoWithMeta(db, c, q, &models)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]Bucket{{{int64(0), 2}, {int64(25), 1}}}, m.Histograms)
+
+	for _, h := range []Histogram{
+		{Col: "iq", Interval: 10},
+		{Col: "age"},
+		{Col: "age", Interval: 10, Unit: "day"},
+		{Col: "age", Unit: "decade"},
+	} {
+		q.Histograms = []Histogram{h}
+		_, _, err = DoWithMeta(db, c, q, &models)
+		assert.Error(t, err, h)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		Where:      map[string]string{"age": "> ?"},
+		Aggregates: map[string][]string{"iq": {"sum", "max"}, "age": {"min"}},
+	}
+	q := Query{
+		Page:      1,
+		PageSize:  1,
+		WhereArgs: map[string]interface{}{"age": 40},
+		Summary:   []string{"sum(iq)", "MAX(iq)", "min(age)"},
+	}
+	var models []dbModel
+	res, m, err := DoWithMeta(db, c, q, &models)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Len(t, models, 1)
+	assert.Equal(t, map[string]interface{}{
+		"sum_iq":  int64(281),
+		"max_iq":  int64(120),
+		"min_age": int64(44),
+	}, m.Summary)
+
+	q.Summary = []string{"avg(iq)"}
+	_, _, err = DoWithMeta(db, c, q, &models)
+	assert.EqualError(t, err, `query cannot aggregate "avg(iq)"`)
+}
+
+func TestDistinct(t *testing.T) {
+	db, f := setupPets(t)
+	defer f()
+
+	// Owners repeat once per pet.
+	c := Config{
+		FilterFunc: func(db *gorm.DB, q Query) *gorm.DB {
+			return db.Joins("JOIN pets ON pets.owner_id = owners.id")
+		},
+		TiebreakerCol: "id",
+	}
+	q := Query{Page: 1, CountTotal: true}
+	var owners []owner
+	res, m, err := DoWithMeta(db, c, q, &owners)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Len(t, owners, 3)
+	assert.Equal(t, int64(3), m.Total)
+
+	c.Distinct = true
+	owners = nil
+	res, m, err = DoWithMeta(db, c, q, &owners)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []owner{{ID: 1, Name: "Ann"}, {ID: 2, Name: "Bob"}}, owners)
+	assert.Equal(t, int64(2), m.Total)
+}
+
+func TestCountTotal(t *testing.T) {
+	db, f := setupPets(t)
+	defer f()
+
+	var counts []string
+	db.Callback().RowQuery().After("gorm:row_query").Register("test:count", func(scope *gorm.Scope) {
+		counts = append(counts, scope.SQL)
+	})
+	c := Config{
+		PreloadableAssociations: []string{"Pets"},
+		DefaultOrderBy:          []string{"name"},
+		TiebreakerCol:           "id",
+	}
+	q := Query{Page: 1, PageSize: 1, Include: []string{"pets"}, CountTotal: true}
+	var owners []owner
+	res, m, err := DoWithMeta(db, c, q, &owners)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Len(t, owners, 1)
+	assert.Equal(t, int64(2), m.Total)
+
+	// The rows are counted in any order.
+	if assert.Len(t, counts, 1) {
+		assert.Contains(t, counts[0], "COUNT(*)")
+		assert.NotContains(t, strings.ToUpper(counts[0]), "ORDER BY")
+	}
+}
+
+func TestDistinctOn(t *testing.T) {
+	db, f := setupPets(t)
+	defer f()
+
+	// The first pet of each species by name.
+	c := Config{
+		Where:         map[string]string{"owner_id": "= ?"},
+		OrderableCols: []string{"name"},
+		DistinctOn:    []string{"species"},
+		TiebreakerCol: "id",
+	}
+	q := Query{Page: 1, OrderBy: []string{"name"}, CountTotal: true}
+	var pets []pet
+	res, m, err := DoWithMeta(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []pet{
+		{ID: 3, Name: "Kit", Species: "cat", OwnerID: 1},
+		{ID: 1, Name: "Rex", Species: "dog", OwnerID: 1},
+	}, pets)
+	assert.Equal(t, int64(2), m.Total)
+
+	// Filters apply before picking the first rows.
+	q.WhereArgs = map[string]interface{}{"owner_id": 2}
+	pets = nil
+	res, m, err = DoWithMeta(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []pet{{ID: 2, Name: "Tom", Species: "cat", OwnerID: 2}}, pets)
+	assert.Equal(t, int64(1), m.Total)
+
+	// Has-many relations only filter, they do not repeat rows.
+	c = Config{
+		Relations:  map[string]Relation{"pet": {Association: "Pets"}},
+		Where:      map[string]string{"pet.species": "= ?"},
+		DistinctOn: []string{"id"},
+	}
+	q = Query{Page: 1, WhereArgs: map[string]interface{}{"pet.species": "dog"}, CountTotal: true}
+	var owners []owner
+	res, m, err = DoWithMeta(db, c, q, &owners)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []owner{{ID: 1, Name: "Ann"}}, owners)
+	assert.Equal(t, int64(1), m.Total)
+}
+
+func TestFind(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{OrderableCols: []string{"id"}}
+	page, err := Find[dbModel](db, c, Query{Page: 3, PageSize: 3, OrderBy: []string{"id"}})
+	assert.NoError(t, err)
+	assert.Equal(t, Page[dbModel]{
+		Items:    []dbModel{{ID: 7, Name: "Smart Guy", Age: 44, IQ: 30}},
+		Page:     3,
+		PageSize: 3,
+		Meta:     &Meta{},
+	}, page)
+
+	// Errors of the query and of the database alike.
+	_, err = Find[dbModel](db, c, Query{Page: 0})
+	assert.Error(t, err)
+	c.FilterFunc = func(db *gorm.DB, q Query) *gorm.DB {
+		return db.Where("no_such_column = 1")
+	}
+	_, err = Find[dbModel](db, c, Query{Page: 1})
+	assert.Error(t, err)
+}
+
+func TestAll(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		Where:         map[string]string{"age": "> ?"},
+		OrderableCols: []string{"id", "name"},
+		TiebreakerCol: "id",
+	}
+	collect := func(ctx context.Context, q Query) ([]int64, error) {
+		var ids []int64
+		for m, err := range All[dbModel](ctx, db, c, q) {
+			if err != nil {
+				return ids, err
+			}
+			ids = append(ids, m.ID)
+		}
+		return ids, nil
+	}
+
+	// By keyset, in either direction.
+	q := Query{PageSize: 2, WhereArgs: map[string]interface{}{"age": 10}}
+	ids, err := collect(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 4, 6, 7}, ids)
+	q.OrderBy = []string{"id desc"}
+	ids, err = collect(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{7, 6, 4, 2, 1}, ids)
+	ok, desc := keysetOrder(&c, &q)
+	assert.True(t, ok)
+	assert.True(t, desc)
+
+	// By page number.
+	q.OrderBy = []string{"name"}
+	ids, err = collect(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 6, 4, 2, 7}, ids)
+	ok, _ = keysetOrder(&c, &q)
+	assert.False(t, ok)
+	dc := c
+	dc.DistinctOn = []string{"age"}
+	ok, _ = keysetOrder(&dc, &Query{})
+	assert.False(t, ok)
+
+	// Stopping early.
+	for range All[dbModel](context.Background(), db, c, q) {
+		break
+	}
+
+	// Errors stop the iteration.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = collect(ctx, q)
+	assert.Equal(t, context.Canceled, err)
+	q.OrderBy = []string{"iq"}
+	_, err = collect(context.Background(), q)
+	assert.EqualError(t, err, `query cannot order by field "iq"`)
+}
+
+func TestStream(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		Where:         map[string]string{"age": "> ?"},
+		MaxPageSize:   2,
+		TiebreakerCol: "id",
+	}
+	q := Query{Page: 1, PageSize: 1, WhereArgs: map[string]interface{}{"age": 40}}
+	var names []string
+	err := Stream(context.Background(), db, c, q, func(m dbModel) error {
+		names = append(names, m.Name)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Don Jr", "Potranka", "Meh", "Holliams", "Smart Guy"}, names)
+
+	// Capped.
+	c.MaxExportRows = 3
+	names = nil
+	err = Stream(context.Background(), db, c, q, func(m dbModel) error {
+		names = append(names, m.Name)
+		return nil
+	})
+	assert.EqualError(t, err, "query matches more than 3 rows")
+	assert.Equal(t, []string{"Don Jr", "Potranka", "Meh"}, names)
+
+	// Stopped by the callback.
+	stop := errors.New("stop")
+	n := 0
+	err = Stream(context.Background(), db, c, q, func(m dbModel) error {
+		n++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, n)
+
+	q.Include = []string{"owner"}
+	err = Stream(context.Background(), db, c, q, func(m dbModel) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestExport(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		Aliases:        map[string]string{"years": "age"},
+		SelectableCols: []string{"name", "age"},
+		ComputedCols:   map[string]ComputedCol{"young": {Expr: "CASE WHEN age < 40 THEN age END"}},
+		Where:          map[string]string{"iq": "> ?"},
+		TiebreakerCol:  "id",
+	}
+	q := Query{
+		Select:    []string{"Name", "years", "young"},
+		WhereArgs: map[string]interface{}{"iq": 90},
+	}
+
+	var buf bytes.Buffer
+	err := WriteCSV[dbModel](context.Background(), &buf, db, c, q, ExportOptions{Null: "NULL"})
+	assert.NoError(t, err)
+	assert.Equal(t, "name,years,young\nTest Dude,7,7\nMeh,77,NULL\nBlah,3,3\n", buf.String())
+
+	buf.Reset()
+	err = WriteNDJSON[dbModel](context.Background(), &buf, db, c, q, ExportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"Test Dude","years":7,"young":7}
+{"name":"Meh","years":77,"young":null}
+{"name":"Blah","years":3,"young":3}
+`, buf.String())
+
+	// Names come from the columns read, not the position in Select.
+	buf.Reset()
+	err = WriteCSV[dbModel](context.Background(), &buf, db, Config{}, Query{Select: []string{"*", "name"}}, ExportOptions{})
+	assert.NoError(t, err)
+	header, _, _ := strings.Cut(buf.String(), "\n")
+	assert.Equal(t, "id,name,age,iq,name", header)
+
+	// Without Select, columns are named by their aliases. The header comes
+	// even without rows.
+	buf.Reset()
+	q.Select = nil
+	q.WhereArgs["iq"] = 1000
+	err = WriteCSV[dbModel](context.Background(), &buf, db, c, q, ExportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "name,years,young\n", buf.String())
+
+	// Times.
+	tm := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	assert.Equal(t, "2019-03-04T05:06:07Z", exportValue(ExportOptions{}, tm))
+	assert.Equal(t, "2019-03-04", exportValue(ExportOptions{TimeFormat: "2006-01-02"}, tm))
+}
+
+func TestRelations(t *testing.T) {
+	db, f := setupPets(t)
+	defer f()
+
+	// Relations are only joined when used.
+	c := Config{
+		Relations:     map[string]Relation{"owner": {Association: "Owner"}},
+		Where:         map[string]string{"owner.name": "= ?", "species": "= ?"},
+		OrderableCols: []string{"name", "owner.name"},
+	}
+	assert.Empty(t, relations(&c, &Query{WhereArgs: map[string]interface{}{"species": "cat"}}))
+	assert.Equal(t, []string{"owner"}, relations(&c, &Query{OrderBy: []string{"owner.name"}}))
+
+	// Belongs to.
+	q := Query{
+		Page:      1,
+		WhereArgs: map[string]interface{}{"owner.name": "Ann"},
+		OrderBy:   []string{"name desc"},
+	}
+	var pets []pet
+	res, err := Do(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []pet{
+		{ID: 1, Name: "Rex", Species: "dog", OwnerID: 1},
+		{ID: 3, Name: "Kit", Species: "cat", OwnerID: 1},
+	}, pets)
+
+	// Joined by SQL.
+	c = Config{
+		Relations:     map[string]Relation{"pet": {Join: "JOIN pets AS pet ON pet.owner_id = owners.id"}},
+		Where:         map[string]string{"pet.species": "= ?"},
+		OrderableCols: []string{"pet.name"},
+	}
+	q = Query{
+		Page:      1,
+		WhereArgs: map[string]interface{}{"pet.species": "cat"},
+		OrderBy:   []string{"pet.name"},
+	}
+	var owners []owner
+	res, err = Do(db, c, q, &owners)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []owner{{ID: 1, Name: "Ann"}, {ID: 2, Name: "Bob"}}, owners)
+
+	// Joined by has-many association.
+	c.Relations["pet"] = Relation{Association: "Pets"}
+	res, err = Do(db, c, q, &owners)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []owner{{ID: 1, Name: "Ann"}, {ID: 2, Name: "Bob"}}, owners)
+
+	// Unknown associations.
+	c.Relations["pet"] = Relation{Association: "Toys"}
+	_, err = Do(db, c, q, &owners)
+	assert.Error(t, err)
+
+	// Searched columns are qualified too.
+	c = Config{
+		Relations: map[string]Relation{"owner": {Association: "Owner"}},
+		Where:     map[string]string{"owner.name": "= ?", "name": "LIKE ?"},
+	}
+	q = Query{
+		Page:      1,
+		WhereArgs: map[string]interface{}{"owner.name": "Ann"},
+		Search:    "%e%",
+		OrderBy:   []string{RelevanceKey},
+	}
+	pets = nil
+	res, err = Do(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []pet{{ID: 1, Name: "Rex", Species: "dog", OwnerID: 1}}, pets)
+	c.SearchCols = []SearchCol{{Name: "name", Mode: SearchContains}}
+	q.Search = "e"
+	res, err = Do(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []pet{{ID: 1, Name: "Rex", Species: "dog", OwnerID: 1}}, pets)
+
+	// Searched relation columns are matched in Go against the preloaded
+	// association.
+	c = Config{
+		Relations:               map[string]Relation{"owner": {Association: "Owner"}},
+		SearchCols:              []SearchCol{{Name: "owner.name", Mode: SearchFuzzy}},
+		PreloadableAssociations: []string{"Owner"},
+		DefaultOrderBy:          []string{"id"},
+	}
+	q = Query{Page: 1, Search: "Ana", Include: []string{"owner"}}
+	pets = nil
+	res, err = Do(db, c, q, &pets)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	ann := &owner{ID: 1, Name: "Ann"}
+	assert.Equal(t, []pet{
+		{ID: 1, Name: "Rex", Species: "dog", OwnerID: 1, Owner: ann},
+		{ID: 3, Name: "Kit", Species: "cat", OwnerID: 1, Owner: ann},
+	}, pets)
+	c.SearchCols[0].Mode = SearchContains
+	q.Search = "nn"
+	m, err := Highlight(db, c, q, pets)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]Match{
+		{{Col: "owner.name", Ranges: [][2]int{{1, 3}}}},
+		{{Col: "owner.name", Ranges: [][2]int{{1, 3}}}},
+	}, m)
+
+	// Or against the columns they and expressions are selected as.
+	type ownedPet struct {
+		ID        int64
+		OwnerName string
+		Label     string
+	}
+	c = Config{
+		Relations:      map[string]Relation{"owner": {Join: "JOIN owners AS owner ON owner.id = pets.owner_id"}},
+		Expressions:    map[string]string{"label": "pets.name || ' the ' || species"},
+		SearchCols:     []SearchCol{{Name: "owner.name", Mode: SearchFuzzy}, {Name: "label", Mode: SearchFuzzy}},
+		DefaultOrderBy: []string{"id"},
+	}
+	q = Query{Page: 1, Search: "Bobb"}
+	var owned []ownedPet
+	res, err = Do(db.Table("pets"), c, q, &owned)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []ownedPet{{ID: 2, OwnerName: "Bob", Label: "Tom the cat"}}, owned)
+	q.Search = "Tom"
+	owned = nil
+	res, err = Do(db.Table("pets"), c, q, &owned)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []ownedPet{{ID: 2, OwnerName: "Bob", Label: "Tom the cat"}}, owned)
+}
+
+func TestInclude(t *testing.T) {
+	db, f := setupPets(t)
+	defer f()
+
+	c := Config{
+		PreloadableAssociations: []string{"Pets.Owner"},
+		IncludeLimits:           map[string]int{"Pets": 1},
+		DefaultOrderBy:          []string{"id"},
+	}
+	q := Query{
+		Page:    1,
+		Include: []string{"pets"},
+	}
+	var owners []owner
+	res, err := Do(db, c, q, &owners)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []owner{
+		{ID: 1, Name: "Ann", Pets: []pet{{ID: 1, Name: "Rex", Species: "dog", OwnerID: 1}}},
+		{ID: 2, Name: "Bob", Pets: []pet{{ID: 2, Name: "Tom", Species: "cat", OwnerID: 2}}},
+	}, owners)
+
+	// The database only returns the first pets.
+	cc := c
+	cc.model = &owners
+	l, err := includeLimit(db, &cc, "Pets")
+	assert.NoError(t, err)
+	assert.Equal(t, "(SELECT COUNT(*) FROM pets AS include_rank WHERE include_rank.owner_id = pets.owner_id AND include_rank.id < pets.id) < 1", l)
+
+	// Only has-many associations are limited.
+	c.IncludeLimits = map[string]int{"Pets.Owner": 1}
+	q.Include = []string{"pets.owner"}
+	_, err = Do(db, c, q, &owners)
+	assert.EqualError(t, err, `include limit of "Pets.Owner" needs a has-many association`)
+
+	// Nested.
+	c.IncludeLimits = nil
+	q.Include = []string{"Pets.owner"}
+	q.PageSize = 1
+	res, err = Do(db, c, q, &owners)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	ann := &owner{ID: 1, Name: "Ann"}
+	assert.Equal(t, []owner{
+		{ID: 1, Name: "Ann", Pets: []pet{
+			{ID: 1, Name: "Rex", Species: "dog", OwnerID: 1, Owner: ann},
+			{ID: 3, Name: "Kit", Species: "cat", OwnerID: 1, Owner: ann},
+		}},
+	}, owners)
+
+	// Too deep.
+	c.MaxIncludeDepth = 1
+	_, err = Do(db, c, q, &owners)
+	assert.Error(t, err)
+
+	// Not preloadable.
+	q.Include = []string{"toys"}
+	_, err = Do(db, c, q, &owners)
+	assert.Error(t, err)
+}
+
+func TestIncludeFields(t *testing.T) {
+	db, f := setupPets(t)
+	defer f()
+
+	c := Config{
+		PreloadableAssociations: []string{"Pets.Owner"},
+		PreloadSelectableCols:   map[string][]string{"Pets": {"name", "species"}},
+		DefaultOrderBy:          []string{"id"},
+	}
+	q := Query{
+		Page:     1,
+		PageSize: 1,
+		Include:  []string{"pets"},
+		Fields:   map[string][]string{"PETS": {" name, "}, "toys": {"color"}},
+	}
+	var owners []owner
+	res, err := Do(db, c, q, &owners)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []owner{
+		{ID: 1, Name: "Ann", Pets: []pet{
+			{ID: 1, Name: "Rex", OwnerID: 1},
+			{ID: 3, Name: "Kit", OwnerID: 1},
+		}},
+	}, owners)
+
+	// Keys of nested associations are selected too.
+	q.Include = []string{"pets.owner"}
+	q.Fields["pets.owner"] = []string{"id"}
+	res, err = Do(db, c, q, &owners)
+	assert.NoError(t, err)
+	assert.NoError(t, res.Error)
+	ann := &owner{ID: 1}
+	assert.Equal(t, []owner{
+		{ID: 1, Name: "Ann", Pets: []pet{
+			{ID: 1, Name: "Rex", OwnerID: 1, Owner: ann},
+			{ID: 3, Name: "Kit", OwnerID: 1, Owner: ann},
+		}},
+	}, owners)
+
+	// Not selectable.
+	q.Fields["pets"] = []string{"name,owner_id"}
+	_, err = Do(db, c, q, &owners)
+	assert.Error(t, err)
+}
+
+func TestFilterFunc(t *testing.T) {
+	db, f := setup(t)
+	defer f()
+
+	c := Config{
+		FilterFunc: func(db *gorm.DB, query Query) *gorm.DB {
+			return db.Where("name NOT LIKE ?", "%dude%")
+		},
+	}
+	q := Query{
+		Page: 1,
+	}
+
+	testPagination(t, db, c, q, [][]dbModel{
+		{
+			{ID: 1, Name: "Don Jr", Age: 46, IQ: 1},
+			{ID: 2, Name: "Potranka", Age: 44, IQ: 80},
+			{ID: 4, Name: "Meh", Age: 77, IQ: 120},
+			{ID: 5, Name: "Blah", Age: 3, IQ: 100},
+			{ID: 6, Name: "Holliams", Age: 99, IQ: 50},
+			{ID: 7, Name: "Smart Guy", Age: 44, IQ: 30},
+		},
+	})
+}
+
+func TestInvalidQueryPage(t *testing.T) {
 	db, f := setup(t)
 	defer f()
 
@@ -685,6 +2302,37 @@ func createDB() (*gorm.DB, func()) {
 	return gdb, func() { os.Remove(dbName) }
 }
 
+type owner struct {
+	ID   int64
+	Name string
+	Pets []pet
+}
+
+type pet struct {
+	ID      int64
+	Name    string
+	Species string
+	OwnerID int64
+	Owner   *owner
+}
+
+func setupPets(t *testing.T) (*gorm.DB, func()) {
+	gdb, f := createDB()
+	if err := gdb.AutoMigrate(&owner{}, &pet{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range []pet{
+		{ID: 1, Name: "Rex", Species: "dog", Owner: &owner{ID: 1, Name: "Ann"}},
+		{ID: 2, Name: "Tom", Species: "cat", Owner: &owner{ID: 2, Name: "Bob"}},
+		{ID: 3, Name: "Kit", Species: "cat", OwnerID: 1},
+	} {
+		if err := gdb.Create(&p).Error; err != nil {
+			t.Fatalf("error creating record %d:%s", i, err)
+		}
+	}
+	return gdb, f
+}
+
 func setup(t *testing.T) (*gorm.DB, func()) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -8,41 +8,167 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"unicode"
 
 	"github.com/jinzhu/gorm"
 )
 
 func build(db *gorm.DB, c *Config, q *Query) (*gorm.DB, error) {
+	db, err := query(db, c, q)
+	if err != nil {
+		return nil, err
+	}
+	if q.Page <= 0 {
+		return nil, fmt.Errorf("invalid page: %d", q.Page)
+	}
+	pageSize := pageSize(c, q)
+	offset := uint64(pageSize) * uint64(q.Page-1)
+	return db.Offset(offset).Limit(pageSize), nil
+}
+
+// query builds everything but the pagination.
+func query(db *gorm.DB, c *Config, q *Query) (*gorm.DB, error) {
+	c.dialect = db.Dialect().GetName()
+	rels := relations(c, q)
+	if (len(rels) > 0 || c.Distinct || len(c.DistinctOn) > 0) && c.model != nil {
+		// Columns of the queried table could be ambiguous once joined, by
+		// relations or by the FilterFunc that makes rows repeat.
+		c.table = db.NewScope(c.model).TableName()
+	}
+	base := db
+	for _, r := range rels {
+		j, err := joinSQL(db, c, r)
+		if err != nil {
+			return nil, err
+		}
+		db = db.Joins(j)
+	}
+	joined := db
+	if grouping(q) {
+		return grouped(db, c, q)
+	}
+	if distinctOn(c) != "" && c.dialect != "postgres" {
+		// The rows are filtered by firstRows, so the joins that filter them
+		// would only make them repeat. Only those selected or ordered by are
+		// needed.
+		db = base
+		oq := *q
+		oq.WhereArgs, oq.Search = nil, ""
+		for _, r := range relations(c, &oq) {
+			j, err := joinSQL(db, c, r)
+			if err != nil {
+				return nil, err
+			}
+			db = db.Joins(j)
+		}
+	}
+	if c.model != nil && len(c.SelectableCols) == 0 && (len(c.HiddenCols) > 0 || len(c.DefaultExcludedCols) > 0) {
+		// Everything but the excluded columns means naming them all.
+		for _, f := range db.NewScope(c.model).GetModelStruct().StructFields {
+			if f.IsNormal && !f.IsIgnored {
+				c.modelCols = append(c.modelCols, f.DBName)
+			}
+		}
+	}
 	s, err := selectCols(c, q)
 	if err != nil {
 		return nil, err
 	}
-	if s != "" {
-		db = db.Select(s)
+	if s == "*" && c.table != "" {
+		s = c.table + ".*"
+	}
+	if fuzzyInMemory(c, q) {
+		// The Search term is matched in Go, which must read what it searches.
+		s = withSearchCols(c, s)
+	}
+	h, ha, err := headlines(c, q)
+	if err != nil {
+		return nil, err
+	}
+	if h != "" {
+		if s == "" {
+			s = "*"
+		}
+		s += ", " + h
+	}
+	if s != "" || c.Distinct || len(c.DistinctOn) > 0 {
+		db = db.Select(distinctSelect(c, s), ha...)
 	}
 	w, wa, err := where(c, q)
 	if err != nil {
 		return nil, err
 	}
-	if w != "" {
-		db = db.Where(w, wa...)
+	o, oa, err := orderBy(c, q)
+	if err != nil {
+		return nil, err
+	}
+	if on := distinctOn(c); on != "" && c.dialect == "postgres" {
+		// DISTINCT ON keeps the first row of each group, so the order must
+		// start with the group.
+		if o == "" {
+			o = on
+		} else {
+			o = on + ", " + o
+		}
 	}
-	o, err := orderBy(c, q)
+	if o != "" && !c.unordered {
+		db = db.Order(gorm.Expr(o, oa...))
+	}
+	incs, err := includes(c, q)
 	if err != nil {
 		return nil, err
 	}
-	if o != "" {
-		db = db.Order(o)
+	for _, inc := range incs {
+		cols, err := includeFields(db, c, q, inc, incs)
+		if err != nil {
+			return nil, err
+		}
+		limit, err := includeLimit(db, c, inc)
+		if err != nil {
+			return nil, err
+		}
+		if len(cols) == 0 && limit == "" {
+			db = db.Preload(inc)
+			continue
+		}
+		db = db.Preload(inc, func(db *gorm.DB) *gorm.DB {
+			if len(cols) > 0 {
+				db = db.Select(cols)
+			}
+			if limit != "" {
+				db = db.Where(limit)
+			}
+			return db
+		})
 	}
-	if q.Page <= 0 {
-		return nil, fmt.Errorf("invalid page: %d", q.Page)
+	filter := func(db *gorm.DB) *gorm.DB {
+		if w != "" {
+			db = db.Where(w, wa...)
+		}
+		if c.FilterFunc != nil {
+			db = c.FilterFunc(db, filterQuery(c, q))
+		}
+		return db
 	}
-	pageSize := pageSize(c, q)
-	offset := uint64(pageSize) * uint64(q.Page-1)
-	if c.FilterFunc != nil {
-		db = c.FilterFunc(db, *q)
+	if distinctOn(c) != "" && c.dialect != "postgres" {
+		// The filters select the first rows of the groups. The query only
+		// needs to fetch them.
+		cond, sub, err := firstRows(filter(joined), c, o, oa)
+		if err != nil {
+			return nil, err
+		}
+		return db.Where(cond, sub), nil
 	}
-	return db.Offset(offset).Limit(pageSize), nil
+	return filter(db), nil
+}
+
+// filterQuery returns the Query that Config.FilterFunc is called with when q
+// is built.
+func filterQuery(c *Config, q *Query) Query {
+	if c.filterQuery != nil {
+		return *c.filterQuery
+	}
+	return *q
 }
 
 func pageSize(c *Config, q *Query) uint16 {
@@ -63,46 +189,93 @@ func pageSize(c *Config, q *Query) uint16 {
 }
 
 // orderBy builds the ORDER BY clause.
-func orderBy(c *Config, q *Query) (string, error) {
+func orderBy(c *Config, q *Query) (string, []interface{}, error) {
 	var buf bytes.Buffer
+	var args []interface{}
+
+	// The default order comes from the config, so it is trusted.
+	obs, trusted := q.OrderBy, false
+	if blank(obs) {
+		obs, trusted = c.DefaultOrderBy, true
+	}
+	tiebroken := false
 
 Outer:
-	for _, o := range q.OrderBy {
-		oo := strings.ToLower(strings.TrimSpace(o))
-		ob := strings.Split(oo, " ")
-		if len(ob[0]) == 0 {
+	for _, o := range orderEntries(c, obs) {
+		ob, ok, err := parseOrder(c, o)
+		if err != nil {
+			return "", nil, err
+		}
+		if !ok {
 			// We got an empty order by. Nothing to do.
 			continue
 		}
-		if len(ob) > 2 {
-			return "", fmt.Errorf("invalid order_by clause %q", o)
+		ob.col = column(c, ob.col)
+		if strings.EqualFold(ob.col, c.TiebreakerCol) {
+			tiebroken = true
 		}
-		if len(ob) == 2 {
-			if ob[1] != "asc" && ob[1] != "desc" {
-				return "", fmt.Errorf("invalid sort direction in order_by clause %q", o)
+		if ob.col == RelevanceKey {
+			if q.Search == "" {
+				// Nothing to be relevant to.
+				continue
 			}
+			r, ra, err := relevance(c, q)
+			if err != nil {
+				return "", nil, err
+			}
+			if r == "" {
+				continue
+			}
+			if ob.dir == "" {
+				ob.dir = "desc"
+			}
+			pad(&buf, ", ")
+			buf.WriteString(r)
+			buf.WriteString(" ")
+			buf.WriteString(ob.dir)
+			args = append(args, ra...)
+			continue
+		}
+		if trusted {
+			pad(&buf, ", ")
+			buf.WriteString(orderTerm(c, colExpr(c, ob.col), ob))
+			continue
+		}
+		_, _, isJSON := jsonPath(c, ob.col)
+		cc, computed := computedCol(c, ob.col)
+		if isJSON || (computed && cc.Orderable && !hidden(c, ob.col)) {
+			pad(&buf, ", ")
+			buf.WriteString(orderTerm(c, colExpr(c, ob.col), ob))
+			continue
 		}
 		for _, oc := range c.OrderableCols {
-			if strings.EqualFold(ob[0], oc) {
+			if strings.EqualFold(ob.col, oc) && !hidden(c, ob.col) {
 				pad(&buf, ", ")
-				buf.WriteString(oo)
+				buf.WriteString(orderTerm(c, colExpr(c, ob.col), ob))
 				continue Outer
 			}
 		}
-		return "", fmt.Errorf("query cannot order by field %q", o)
+		return "", nil, fmt.Errorf("query cannot order by field %q", o)
 	}
-	return buf.String(), nil
+	// Unless the order already ends in a unique column, rows that compare equal
+	// could come in any order and repeat across pages.
+	if c.TiebreakerCol != "" && !tiebroken {
+		pad(&buf, ", ")
+		buf.WriteString(colExpr(c, strings.ToLower(c.TiebreakerCol)))
+	}
+	return buf.String(), args, nil
 }
 
 // selectCols builds the SELECT clause.
 func selectCols(c *Config, q *Query) (string, error) {
 	var buf bytes.Buffer
+	excludes := len(c.HiddenCols) > 0 || len(c.DefaultExcludedCols) > 0
 
 	// No mention of a selectable column means all columns are allowed.
-	if len(c.SelectableCols) == 0 {
+	if len(c.SelectableCols) == 0 && !excludes {
 		// If the query did not specify, select everything.
 		if len(q.Select) == 0 {
-			return "*", nil
+			return withComputed(c, "*"), nil
 		}
 	}
 
@@ -113,29 +286,96 @@ Outer:
 			// We got an empty select. Nothing to do.
 			continue
 		}
+		col := column(c, s)
+		if hidden(c, col) {
+			return "", fmt.Errorf("query cannot select column %q", s)
+		}
 		// If we don't restrict any columns, whatever comes can be added.
-		if len(c.SelectableCols) == 0 {
+		// Computed columns and JSON paths are always allowed. Excluded columns
+		// restrict the others to known ones, lest "*" or "table.col" reach a
+		// hidden column.
+		_, computed := computedCol(c, col)
+		if _, _, ok := jsonPath(c, col); ok || computed || (len(c.SelectableCols) == 0 && !excludes) {
+			pad(&buf, ", ")
+			buf.WriteString(selectExpr(c, col))
+		} else if len(c.SelectableCols) == 0 {
+			if !selectable(c, col) {
+				return "", fmt.Errorf("query cannot select column %q", s)
+			}
 			pad(&buf, ", ")
-			buf.WriteString(s)
+			buf.WriteString(selectExpr(c, col))
 		} else {
 			for _, sc := range c.SelectableCols {
-				if strings.EqualFold(s, sc) {
+				if strings.EqualFold(col, sc) {
 					pad(&buf, ", ")
-					buf.WriteString(s)
+					buf.WriteString(selectExpr(c, col))
 					continue Outer
 				}
 			}
 			return "", fmt.Errorf("query cannot select column %q", s)
 		}
 	}
+	if buf.Len() > 0 {
+		return buf.String(), nil
+	}
+	if len(c.SelectableCols) == 0 && !excludes {
+		if len(c.ComputedCols) == 0 {
+			return "", nil
+		}
+		return withComputed(c, "*"), nil
+	}
 	// If we did not select anything, then we select *everything* that *can* be
-	// selected (but for efficiency not "*").
-	if buf.Len() == 0 {
-		return strings.ToLower(strings.Join(c.SelectableCols, ", ")), nil
+	// selected (but for efficiency not "*"), except for the columns excluded
+	// by default.
+	cols := c.SelectableCols
+	if len(cols) == 0 {
+		if len(c.modelCols) == 0 {
+			return "", fmt.Errorf("cannot exclude columns without knowing the model's columns")
+		}
+		cols = c.modelCols
+	}
+	for _, cc := range computedNames(c) {
+		if !contains(cols, cc) {
+			cols = append(cols, cc)
+		}
+	}
+	for _, sc := range cols {
+		if hidden(c, sc) || contains(c.DefaultExcludedCols, sc) {
+			continue
+		}
+		pad(&buf, ", ")
+		buf.WriteString(selectExpr(c, strings.ToLower(sc)))
 	}
 	return buf.String(), nil
 }
 
+// selectable reports whether col may be selected when columns are excluded but
+// Config.SelectableCols is empty: it must name a column of the model, an
+// expression or a column of a relation.
+func selectable(c *Config, col string) bool {
+	if contains(c.modelCols, col) {
+		return true
+	}
+	if _, ok := expression(c, col); ok {
+		return true
+	}
+	_, rc, ok := relationCol(c, col)
+	return ok && identifier(rc)
+}
+
+// identifier reports whether s is a plain SQL identifier.
+func identifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
 // where builds the WHERE clause.
 func where(c *Config, q *Query) (string, []interface{}, error) {
 	var args []interface{}
@@ -148,10 +388,13 @@ func where(c *Config, q *Query) (string, []interface{}, error) {
 	// Maps are unsorted so we sort the keys to ensure testable results.
 	keys := make([]string, 0, len(q.WhereArgs))
 	valuesWithNewKeys := make(map[string]interface{})
+	publicKeys := make(map[string]string)
 	for k, v := range q.WhereArgs {
 		kk := strings.ToLower(strings.TrimSpace(k))
-		keys = append(keys, kk)
-		valuesWithNewKeys[kk] = v
+		col := column(c, kk)
+		keys = append(keys, col)
+		valuesWithNewKeys[col] = v
+		publicKeys[col] = kk
 	}
 	sort.Strings(keys)
 
@@ -159,13 +402,20 @@ func where(c *Config, q *Query) (string, []interface{}, error) {
 
 	// We reject WhereArg keys that are not in Where keys.
 	for _, k := range keys {
-		if _, found := c.Where[k]; !found {
-			return "", nil, fmt.Errorf("where argument %q not allowed", k)
+		clause, found := c.Where[k]
+		if cc, ok := computedCol(c, k); !found && ok && cc.Where != "" {
+			clause, found = cc.Where, true
+		}
+		if _, _, ok := jsonPath(c, k); !found && ok {
+			clause, found = "= ?", true
+		}
+		if !found || hidden(c, k) {
+			return "", nil, fmt.Errorf("where argument %q not allowed", publicKeys[k])
 		}
 		pad(&buf, " AND ")
-		buf.WriteString(k)
+		buf.WriteString(colExpr(c, k))
 		buf.WriteString(" ")
-		buf.WriteString(c.Where[k])
+		buf.WriteString(clause)
 		args = append(args, valuesWithNewKeys[k])
 	}
 
@@ -175,35 +425,44 @@ func where(c *Config, q *Query) (string, []interface{}, error) {
 	}
 
 	// When Search is on, we apply the Search to all LIKE queries.
-	keys = likeClauses(c)
-
-	var orBuf bytes.Buffer
-	for _, k := range keys {
-		pad(&orBuf, " OR ")
-		orBuf.WriteString(k)
-		orBuf.WriteString(" ")
-		orBuf.WriteString(c.Where[k])
-		args = append(args, q.Search)
+	sc, sa, err := search(c, q)
+	if err != nil {
+		return "", nil, err
 	}
+	args = append(args, sa...)
 
 	and := buf.Len() > 0
-	or := orBuf.Len() > 0
+	or := sc != ""
 	if and && or {
 		buf.WriteString(" AND (")
-		buf.ReadFrom(&orBuf)
+		buf.WriteString(sc)
 		buf.WriteString(")")
 	} else if or {
-		buf.ReadFrom(&orBuf)
+		buf.WriteString(sc)
 	}
 	return buf.String(), args, nil
 }
 
-// likeClauses returns the sorted keys of all Where clauses that have a "LIKE"
-// or "like" in them.
+// likeClauses returns the sorted keys of all Where clauses that have a LIKE or
+// ILIKE operator in them, but not a NOT LIKE or NOT ILIKE, which no search could
+// match.
 func likeClauses(c *Config) []string {
 	var keys []string
 	for k, v := range c.Where {
-		if strings.Contains(v, "like") || strings.Contains(v, "LIKE") {
+		like, not := false, false
+		words := strings.FieldsFunc(strings.ToLower(v), func(r rune) bool {
+			return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for i, w := range words {
+			if w != "like" && w != "ilike" {
+				continue
+			}
+			like = true
+			if i > 0 && words[i-1] == "not" {
+				not = true
+			}
+		}
+		if like && !not {
 			keys = append(keys, k)
 		}
 	}
@@ -211,6 +470,171 @@ func likeClauses(c *Config) []string {
 	return keys
 }
 
+// column returns the column that Config.Aliases maps the public name to, or
+// name itself if it has no alias.
+func column(c *Config, name string) string {
+	for k, col := range c.Aliases {
+		if strings.EqualFold(k, name) {
+			return strings.ToLower(col)
+		}
+	}
+	return name
+}
+
+// expression returns the SQL expression that Config.Expressions or
+// Config.ComputedCols map name to.
+func expression(c *Config, name string) (string, bool) {
+	for k, e := range c.Expressions {
+		if strings.EqualFold(k, name) {
+			return e, true
+		}
+	}
+	if cc, ok := computedCol(c, name); ok {
+		return cc.Expr, true
+	}
+	return "", false
+}
+
+// computedCol returns the computed column called name in Config.ComputedCols.
+func computedCol(c *Config, name string) (ComputedCol, bool) {
+	for k, cc := range c.ComputedCols {
+		if strings.EqualFold(k, name) {
+			return cc, true
+		}
+	}
+	return ComputedCol{}, false
+}
+
+// computedNames returns the sorted names of Config.ComputedCols.
+func computedNames(c *Config) []string {
+	var names []string
+	for k := range c.ComputedCols {
+		names = append(names, strings.ToLower(k))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withComputed appends the computed columns that are selected by default to
+// star, the selection of all columns.
+func withComputed(c *Config, star string) string {
+	if len(c.ComputedCols) == 0 {
+		return star
+	}
+	if c.table != "" {
+		star = c.table + "." + star
+	}
+	buf := bytes.NewBufferString(star)
+	for _, cc := range computedNames(c) {
+		if hidden(c, cc) || contains(c.DefaultExcludedCols, cc) {
+			continue
+		}
+		pad(buf, ", ")
+		buf.WriteString(selectExpr(c, cc))
+	}
+	return buf.String()
+}
+
+// colExpr returns what the SQL for column name should say: the column itself,
+// the extraction of a JSON path or, if name is in Config.Expressions, its
+// parenthesized expression. Columns of the queried table are qualified with its
+// name if relations are joined.
+func colExpr(c *Config, name string) string {
+	if e, ok := expression(c, name); ok {
+		return "(" + e + ")"
+	}
+	if col, path, ok := jsonPath(c, name); ok {
+		return jsonExpr(c, col, path)
+	}
+	if c.table != "" && !strings.Contains(name, ".") {
+		return c.table + "." + name
+	}
+	return name
+}
+
+// selectExpr returns what the SELECT clause should say for column name.
+// Columns of relations are selected as "relation_column" so they do not
+// clash with those of the queried table.
+func selectExpr(c *Config, name string) string {
+	if e, ok := expression(c, name); ok {
+		return e + " AS " + name
+	}
+	if col, path, ok := jsonPath(c, name); ok {
+		return jsonExpr(c, col, path) + " AS " + resultCol(c, name)
+	}
+	if _, _, ok := relationCol(c, name); ok {
+		return name + " AS " + resultCol(c, name)
+	}
+	return colExpr(c, name)
+}
+
+// resultCol returns the name of the column that selectExpr reads column name
+// into.
+func resultCol(c *Config, name string) string {
+	if _, ok := expression(c, name); ok {
+		return name
+	}
+	if col, path, ok := jsonPath(c, name); ok {
+		return col + "_" + strings.ToLower(strings.Replace(path, ".", "_", -1))
+	}
+	if r, col, ok := relationCol(c, name); ok {
+		return r + "_" + col
+	}
+	return name
+}
+
+// withSearchCols adds to the selection s the searched columns that are not
+// columns of the queried table, unless s already selects them.
+func withSearchCols(c *Config, s string) string {
+	sel := strings.Split(s, ", ")
+	buf := bytes.NewBufferString(s)
+	for _, sc := range searchCols(c) {
+		if _, ok := expression(c, sc.Name); !ok && resultCol(c, sc.Name) == sc.Name {
+			continue
+		}
+		e := selectExpr(c, sc.Name)
+		if contains(sel, e) {
+			continue
+		}
+		if buf.Len() == 0 {
+			// Nothing selected means everything.
+			buf.WriteString("*")
+			if c.table != "" {
+				buf.Reset()
+				buf.WriteString(c.table + ".*")
+			}
+		}
+		buf.WriteString(", " + e)
+		sel = append(sel, e)
+	}
+	return buf.String()
+}
+
+// hidden reports whether col is in Config.HiddenCols.
+func hidden(c *Config, col string) bool {
+	return contains(c.HiddenCols, col)
+}
+
+// contains reports whether ss contains s, ignoring case.
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// blank reports whether all strings in ss are empty or white space.
+func blank(ss []string) bool {
+	for _, s := range ss {
+		if strings.TrimSpace(s) != "" {
+			return false
+		}
+	}
+	return true
+}
+
 // pad adds s to buf if the buffer is not empty.
 func pad(buf *bytes.Buffer, s string) {
 	if buf.Len() > 0 {
@@ -0,0 +1,62 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// distinctOn returns the SQL list of the columns in Config.DistinctOn.
+func distinctOn(c *Config) string {
+	var buf bytes.Buffer
+	for _, d := range c.DistinctOn {
+		pad(&buf, ", ")
+		buf.WriteString(colExpr(c, strings.ToLower(strings.TrimSpace(d))))
+	}
+	return buf.String()
+}
+
+// distinctSelect returns the SELECT clause s with the DISTINCT that Config
+// asks for. On dialects other than Postgres, DISTINCT ON is left to firstRows.
+func distinctSelect(c *Config, s string) string {
+	if s == "" {
+		s = "*"
+		if c.table != "" {
+			s = c.table + ".*"
+		}
+	}
+	if on := distinctOn(c); on != "" {
+		if c.dialect == "postgres" {
+			return "DISTINCT ON (" + on + ") " + s
+		}
+		return s
+	}
+	if c.Distinct {
+		return "DISTINCT " + s
+	}
+	return s
+}
+
+// firstRows returns the condition that emulates DISTINCT ON: the primary key
+// is in the subquery of the keys of the rows that come first in their group,
+// in order o. The rows are those of db.
+func firstRows(db *gorm.DB, c *Config, o string, oa []interface{}) (string, interface{}, error) {
+	if c.model == nil {
+		return "", nil, fmt.Errorf("distinct on needs a model on dialect %q", c.dialect)
+	}
+	pk := db.NewScope(c.model).PrimaryKey()
+	if pk == "" {
+		return "", nil, fmt.Errorf("distinct on needs a primary key on dialect %q", c.dialect)
+	}
+	key := colExpr(c, pk)
+	if o == "" {
+		o = key
+	}
+	sub := db.Model(c.model).Select(fmt.Sprintf("%s AS distinct_key, ROW_NUMBER() OVER (PARTITION BY %s ORDER BY %s) AS distinct_row", key, distinctOn(c), o), oa...)
+	return key + " IN (SELECT distinct_key FROM ? AS ranked WHERE distinct_row = 1)", sub.SubQuery(), nil
+}
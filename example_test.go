@@ -80,3 +80,42 @@ func Example() {
 	// [{1 Bob Smith 48} {2 Joan Of Arc 312} {3 Morihei Ueshiba 69}]
 	// [{5 Silvio Santos 99}]
 }
+
+func ExampleFind() {
+	db, f := createDB()
+	defer f()
+
+	if res := db.AutoMigrate(&Person{}); res.Error != nil {
+		panic(res.Error)
+	}
+	for _, p := range []Person{
+		{1, "Bob Smith", 48},
+		{2, "Joan Of Arc", 312},
+		{3, "John Doe", 19},
+	} {
+		if res := db.Create(&p); res.Error != nil {
+			panic(res.Error)
+		}
+	}
+
+	c := Config{
+		DefaultPageSize: 1,
+		Where:           map[string]string{"age": "> ?"},
+		OrderableCols:   []string{"name"},
+	}
+	q := Query{
+		Page:       2,
+		WhereArgs:  map[string]interface{}{"age": 21},
+		OrderBy:    []string{"name asc"},
+		CountTotal: true,
+	}
+
+	// A single error covers both building and running the query.
+	page, err := Find[Person](db, c, q)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(page.Items, page.Page, page.PageSize, page.Meta.Total)
+	// Output:
+	// [{2 Joan Of Arc 312}] 2 1 2
+}
@@ -0,0 +1,161 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ExportOptions configures how WriteCSV and WriteNDJSON render values.
+type ExportOptions struct {
+	// TimeFormat is the layout of times, as in time.Time.Format. If it is not
+	// set, it defaults to time.RFC3339.
+	TimeFormat string
+
+	// Null is what WriteCSV writes for NULL, e.g. "NULL" or "\\N". It is empty
+	// by default. WriteNDJSON always writes null.
+	Null string
+}
+
+// WriteCSV streams the rows of the query on the model T to w as CSV, like
+// Stream does. The first record is the header: the public names of the
+// selected columns, in the order of the Query's Select.
+func WriteCSV[T any](ctx context.Context, w io.Writer, db *gorm.DB, c Config, q Query, opts ExportOptions) error {
+	cw := csv.NewWriter(w)
+	header := func(cols []string) error {
+		return cw.Write(exportHeader(&c, &q, cols))
+	}
+	err := stream[T](ctx, db, c, q, header, func(db *gorm.DB, rows *sql.Rows) error {
+		vals, err := scanValues(rows)
+		if err != nil {
+			return err
+		}
+		record := make([]string, len(vals))
+		for i, v := range vals {
+			if v == nil {
+				record[i] = opts.Null
+				continue
+			}
+			record[i] = fmt.Sprint(exportValue(opts, v))
+		}
+		return cw.Write(record)
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// WriteNDJSON streams the rows of the query on the model T to w as
+// newline-delimited JSON, like Stream does. Each row is an object whose keys
+// are the public names of the selected columns, in the order of the Query's
+// Select.
+func WriteNDJSON[T any](ctx context.Context, w io.Writer, db *gorm.DB, c Config, q Query, opts ExportOptions) error {
+	bw := bufio.NewWriter(w)
+	var keys [][]byte
+	header := func(cols []string) error {
+		for _, h := range exportHeader(&c, &q, cols) {
+			k, err := json.Marshal(h)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, k)
+		}
+		return nil
+	}
+	err := stream[T](ctx, db, c, q, header, func(db *gorm.DB, rows *sql.Rows) error {
+		vals, err := scanValues(rows)
+		if err != nil {
+			return err
+		}
+		bw.WriteByte('{')
+		for i, v := range vals {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			b, err := json.Marshal(exportValue(opts, v))
+			if err != nil {
+				return err
+			}
+			bw.Write(keys[i])
+			bw.WriteByte(':')
+			bw.Write(b)
+		}
+		_, err = bw.WriteString("}\n")
+		return err
+	})
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// exportHeader returns the public names of the result columns cols: the
+// entries of the Query's Select that are read into them or, for the others,
+// their alias in Config.Aliases, if they have one.
+func exportHeader(c *Config, q *Query, cols []string) []string {
+	selected := make(map[string]string)
+	for _, s := range q.Select {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			selected[resultCol(c, column(c, s))] = s
+		}
+	}
+	header := make([]string, len(cols))
+	for i, col := range cols {
+		name, ok := selected[strings.ToLower(col)]
+		if !ok {
+			name = col
+			for k, v := range c.Aliases {
+				if strings.EqualFold(v, col) {
+					name = k
+				}
+			}
+		}
+		header[i] = name
+	}
+	return header
+}
+
+// scanValues scans the current row of rows into a value per column.
+func scanValues(rows *sql.Rows) ([]interface{}, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// exportValue returns how v is rendered: bytes as text, times as formatted
+// by ExportOptions.TimeFormat and anything else as is.
+func exportValue(opts ExportOptions, v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		if opts.TimeFormat == "" {
+			return v.Format(time.RFC3339)
+		}
+		return v.Format(opts.TimeFormat)
+	}
+	return v
+}
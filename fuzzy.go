@@ -0,0 +1,225 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// defaultFuzzyThreshold is the default for Config.FuzzyThreshold. It is
+	// also the default of Postgres' pg_trgm.similarity_threshold.
+	defaultFuzzyThreshold = 0.3
+
+	// maxFuzzyRows is the default for Config.MaxFuzzyRows.
+	maxFuzzyRows = 10000
+)
+
+// fuzzyThreshold returns the minimum similarity of a fuzzy match.
+func fuzzyThreshold(c *Config) float64 {
+	if c.FuzzyThreshold == 0 {
+		return defaultFuzzyThreshold
+	}
+	return c.FuzzyThreshold
+}
+
+// fuzzyInMemory reports whether the Search term must be matched in Go because
+// some search columns are fuzzy and the database cannot match them.
+func fuzzyInMemory(c *Config, q *Query) bool {
+	if q.Search == "" || c.dialect == "postgres" {
+		return false
+	}
+	for _, sc := range searchCols(c) {
+		if sc.Mode == SearchFuzzy {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzy performs a query whose Search term must be matched in Go. It reads up
+// to Config.MaxFuzzyRows rows matching everything but the Search term, keeps
+// the rows that match it and paginates them in memory. If the query orders by
+// RelevanceKey, rows are sorted by relevance first and the rest of OrderBy only
+// breaks ties.
+func fuzzy(db *gorm.DB, c *Config, q *Query, results interface{}) (*gorm.DB, error) {
+	db, err := query(db, c, q)
+	if err != nil {
+		return nil, err
+	}
+	if q.Page <= 0 {
+		return nil, fmt.Errorf("invalid page: %d", q.Page)
+	}
+	rv := reflect.ValueOf(results)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("results must be a pointer to a slice, got %T", results)
+	}
+	include, exclude := []string{strings.Trim(q.Search, "%")}, []string(nil)
+	if c.TokenizeSearch {
+		include, exclude, err = searchTokens(c, include[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	max := c.MaxFuzzyRows
+	if max == 0 {
+		max = maxFuzzyRows
+	}
+	// The rows are read apart, so that results only ever holds the page.
+	read := reflect.New(rv.Elem().Type())
+	res := db.Limit(max).Find(read.Interface())
+	if res.Error != nil {
+		return res, nil
+	}
+
+	type scored struct {
+		i     int
+		score float64
+	}
+	all := read.Elem()
+	var matched []scored
+	for i := 0; i < all.Len(); i++ {
+		score, ok, err := fuzzyMatch(res, c, include, exclude, all.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, scored{i, score})
+		}
+	}
+	if desc, ok := relevanceOrder(c, q); ok {
+		sort.SliceStable(matched, func(i, j int) bool {
+			if desc {
+				return matched[i].score > matched[j].score
+			}
+			return matched[i].score < matched[j].score
+		})
+	}
+
+	pageSize := uint64(pageSize(c, q))
+	offset := pageSize * uint64(q.Page-1)
+	page := reflect.MakeSlice(all.Type(), 0, int(pageSize))
+	for i := offset; i < uint64(len(matched)) && i < offset+pageSize; i++ {
+		page = reflect.Append(page, all.Index(matched[i].i))
+	}
+	rv.Elem().Set(page)
+	res.RowsAffected = int64(page.Len())
+	return res, nil
+}
+
+// fuzzyMatch reports whether row matches every included search term and none
+// of the excluded ones, and how relevant the match is.
+func fuzzyMatch(db *gorm.DB, c *Config, include, exclude []string, row reflect.Value) (float64, bool, error) {
+	if row.Kind() != reflect.Ptr {
+		row = row.Addr()
+	}
+	scope := db.NewScope(row.Interface())
+
+	var score float64
+	for _, t := range include {
+		matched := false
+		for _, sc := range searchCols(c) {
+			v, ok, err := stringField(scope, c, sc.Name)
+			if err != nil {
+				return 0, false, err
+			}
+			if !ok {
+				continue
+			}
+			s := matchScore(c, sc, v, t)
+			if s == 0 {
+				continue
+			}
+			matched = true
+			if sc.Weight != 0 {
+				s *= sc.Weight
+			}
+			score += s
+		}
+		if !matched {
+			return 0, false, nil
+		}
+	}
+	for _, t := range exclude {
+		for _, sc := range searchCols(c) {
+			v, ok, err := stringField(scope, c, sc.Name)
+			if err != nil {
+				return 0, false, err
+			}
+			if ok && matchScore(c, sc, v, t) > 0 {
+				return 0, false, nil
+			}
+		}
+	}
+	return score, true, nil
+}
+
+// matchScore scores how well v matches search term t according to the mode of
+// column sc. Fuzzy columns score their similarity to t, other columns score 1.
+// Zero means no match.
+func matchScore(c *Config, sc SearchCol, v, t string) float64 {
+	if sc.Mode == SearchFuzzy {
+		if s := similarity(v, t); s >= fuzzyThreshold(c) {
+			return s
+		}
+		return 0
+	}
+	if len(highlight(c, sc, v, t)) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// relevanceOrder reports whether the query orders by RelevanceKey and if so,
+// whether in descending order.
+func relevanceOrder(c *Config, q *Query) (desc bool, ok bool) {
+	for _, o := range orderEntries(c, q.OrderBy) {
+		ob, ok, err := parseOrder(c, o)
+		if err != nil || !ok || ob.col != RelevanceKey {
+			continue
+		}
+		return ob.dir != "asc", true
+	}
+	return false, false
+}
+
+// similarity returns the trigram similarity of a and b as Postgres' pg_trgm
+// computes it: the number of trigrams they share divided by the number of
+// distinct trigrams in both.
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	n := 0
+	for t := range ta {
+		if tb[t] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(ta)+len(tb)-n)
+}
+
+// trigrams returns the set of trigrams of s. Like pg_trgm, it ignores case and
+// non-alphanumeric characters and pads each word with two spaces in front and
+// one behind.
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		rs := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(rs); i++ {
+			set[string(rs[i:i+3])] = true
+		}
+	}
+	return set
+}
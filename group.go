@@ -0,0 +1,228 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// aggregateFuncs are the aggregate functions that Config.Aggregates may allow.
+var aggregateFuncs = []string{"count", "sum", "avg", "min", "max"}
+
+// aggregate is a parsed Query.Aggregates entry.
+type aggregate struct {
+	fn   string // One of aggregateFuncs.
+	col  string // The aggregated column, or "*".
+	name string // What the result is selected as.
+}
+
+// grouping reports whether the query groups rows.
+func grouping(q *Query) bool {
+	return !blank(q.GroupBy) || !blank(q.Aggregates)
+}
+
+// grouped builds a query that groups rows as requested by Query.GroupBy and
+// Query.Aggregates. It is what query builds for grouping queries.
+func grouped(db *gorm.DB, c *Config, q *Query) (*gorm.DB, error) {
+	if !blank(q.Include) {
+		return nil, fmt.Errorf("query cannot include associations of grouped rows")
+	}
+	if fuzzyInMemory(c, q) {
+		return nil, fmt.Errorf("query cannot group rows matched by a fuzzy search")
+	}
+	cols, err := groupCols(c, q)
+	if err != nil {
+		return nil, err
+	}
+	aggs, err := aggregates(c, q)
+	if err != nil {
+		return nil, err
+	}
+	s, err := groupSelect(c, q, cols, aggs)
+	if err != nil {
+		return nil, err
+	}
+	db = db.Select(s)
+	if len(cols) > 0 {
+		var buf bytes.Buffer
+		for _, col := range cols {
+			pad(&buf, ", ")
+			buf.WriteString(colExpr(c, col))
+		}
+		db = db.Group(buf.String())
+	}
+	w, wa, err := where(c, q)
+	if err != nil {
+		return nil, err
+	}
+	if w != "" {
+		db = db.Where(w, wa...)
+	}
+	o, err := groupOrderBy(c, q, cols, aggs)
+	if err != nil {
+		return nil, err
+	}
+	if o != "" && !c.unordered {
+		db = db.Order(o)
+	}
+	if c.FilterFunc != nil {
+		db = c.FilterFunc(db, filterQuery(c, q))
+	}
+	return db, nil
+}
+
+// groupCols returns the columns in Query.GroupBy, as whitelisted by
+// Config.GroupableCols.
+func groupCols(c *Config, q *Query) ([]string, error) {
+	var cols []string
+
+Outer:
+	for _, g := range q.GroupBy {
+		s := strings.ToLower(strings.TrimSpace(g))
+		if s == "" {
+			// We got an empty group by. Nothing to do.
+			continue
+		}
+		col := column(c, s)
+		if !hidden(c, col) {
+			for _, gc := range c.GroupableCols {
+				if strings.EqualFold(col, gc) {
+					if !contains(cols, col) {
+						cols = append(cols, col)
+					}
+					continue Outer
+				}
+			}
+		}
+		return nil, fmt.Errorf("query cannot group by column %q", s)
+	}
+	return cols, nil
+}
+
+// aggregates parses Query.Aggregates, as whitelisted by Config.Aggregates.
+func aggregates(c *Config, q *Query) ([]aggregate, error) {
+	var aggs []aggregate
+	for _, a := range q.Aggregates {
+		s := strings.ToLower(strings.TrimSpace(a))
+		if s == "" {
+			// We got an empty aggregate. Nothing to do.
+			continue
+		}
+		fn, arg := s, "*"
+		if i := strings.Index(s, "("); i >= 0 && strings.HasSuffix(s, ")") {
+			fn, arg = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:len(s)-1])
+		}
+		agg := aggregate{fn: fn, col: arg, name: fn}
+		if arg != "*" {
+			agg.col = column(c, arg)
+			agg.name = fn + "_" + strings.Replace(arg, ".", "_", -1)
+		}
+		if !aggregatable(c, agg) {
+			return nil, fmt.Errorf("query cannot aggregate %q", s)
+		}
+		aggs = append(aggs, agg)
+	}
+	return aggs, nil
+}
+
+// aggregatable reports whether Config.Aggregates allows a.
+func aggregatable(c *Config, a aggregate) bool {
+	if !contains(aggregateFuncs, a.fn) || hidden(c, a.col) || (a.col == "*" && a.fn != "count") {
+		return false
+	}
+	for col, fns := range c.Aggregates {
+		if strings.EqualFold(col, a.col) && contains(fns, a.fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregateExpr returns the SQL that computes a.
+func aggregateExpr(c *Config, a aggregate) string {
+	if a.col == "*" {
+		return strings.ToUpper(a.fn) + "(*)"
+	}
+	return strings.ToUpper(a.fn) + "(" + colExpr(c, a.col) + ")"
+}
+
+// groupSelect builds the SELECT clause of a grouping query: the grouped
+// columns in Query.Select, or all of them, followed by the aggregates.
+func groupSelect(c *Config, q *Query, cols []string, aggs []aggregate) (string, error) {
+	selected := cols
+	if !blank(q.Select) {
+		selected = nil
+		for _, ss := range q.Select {
+			s := strings.ToLower(strings.TrimSpace(ss))
+			if s == "" {
+				continue
+			}
+			col := column(c, s)
+			if !contains(cols, col) {
+				return "", fmt.Errorf("query cannot select column %q", s)
+			}
+			selected = append(selected, col)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, col := range selected {
+		pad(&buf, ", ")
+		buf.WriteString(selectExpr(c, col))
+	}
+	for _, a := range aggs {
+		pad(&buf, ", ")
+		buf.WriteString(aggregateExpr(c, a))
+		buf.WriteString(" AS ")
+		buf.WriteString(a.name)
+	}
+	return buf.String(), nil
+}
+
+// groupOrderBy builds the ORDER BY clause of a grouping query. It may order by
+// the grouped columns and by the aggregates as named in the results, e.g.
+// "count desc" or "sum_total". The grouped columns are unique together, so
+// they break ties in place of Config.TiebreakerCol.
+func groupOrderBy(c *Config, q *Query, cols []string, aggs []aggregate) (string, error) {
+	var buf bytes.Buffer
+	var ordered []string
+
+Outer:
+	for _, o := range orderEntries(c, q.OrderBy) {
+		ob, ok, err := parseOrder(c, o)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			// We got an empty order by. Nothing to do.
+			continue
+		}
+		ob.col = column(c, ob.col)
+		ordered = append(ordered, ob.col)
+		if contains(cols, ob.col) {
+			pad(&buf, ", ")
+			buf.WriteString(orderTerm(c, colExpr(c, ob.col), ob))
+			continue
+		}
+		for _, a := range aggs {
+			if a.name == ob.col {
+				pad(&buf, ", ")
+				buf.WriteString(orderTerm(c, aggregateExpr(c, a), ob))
+				continue Outer
+			}
+		}
+		return "", fmt.Errorf("query cannot order by field %q", o)
+	}
+	for _, col := range cols {
+		if !contains(ordered, col) {
+			pad(&buf, ", ")
+			buf.WriteString(colExpr(c, col))
+		}
+	}
+	return buf.String(), nil
+}
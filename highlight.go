@@ -0,0 +1,220 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Match describes where the Search term matched a column of a result row.
+type Match struct {
+	// Col is the name of the searched column.
+	Col string
+
+	// Ranges are the [start, end) byte offsets of the matches in the column
+	// value.
+	Ranges [][2]int
+}
+
+// Highlight returns the matches of the Query's Search term in the searched
+// columns of each row in results, which is what was passed to Do. The i-th
+// element of the returned slice holds the matches of the i-th row, in the order
+// the columns are searched. Rows and columns without matches are omitted from
+// their respective lists. Columns of relations, expressions and JSON paths are
+// read from the fields they are selected into, e.g. OwnerName for "owner.name",
+// and columns of relations otherwise from their preloaded association.
+//
+// Matching happens in Go and ignores case. Contains and prefix columns match as
+// they do in the database and fuzzy columns match as a whole. Full-text columns
+// and tokenized searches match every word or token wherever it occurs, which
+// only approximates what the database matched. On Postgres, Config.Headlines
+// is an alternative that lets the database do it.
+func Highlight(db *gorm.DB, c Config, q Query, results interface{}) ([][]Match, error) {
+	rv := reflect.Indirect(reflect.ValueOf(results))
+	if rv.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("results must be a slice or a pointer to a slice, got %T", results)
+	}
+	terms := searchTerms(&c, &q)
+	cols := searchCols(&c)
+
+	matches := make([][]Match, rv.Len())
+	if q.Search == "" {
+		return matches, nil
+	}
+	for i := 0; i < rv.Len(); i++ {
+		row := rv.Index(i)
+		if row.Kind() != reflect.Ptr {
+			row = row.Addr()
+		}
+		scope := db.NewScope(row.Interface())
+		for _, sc := range cols {
+			v, ok, err := stringField(scope, &c, sc.Name)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				// Nothing to highlight.
+				continue
+			}
+			var ranges [][2]int
+			for _, t := range terms {
+				ranges = append(ranges, highlight(&c, sc, v, t)...)
+			}
+			if len(ranges) > 0 {
+				matches[i] = append(matches[i], Match{Col: sc.Name, Ranges: ranges})
+			}
+		}
+	}
+	return matches, nil
+}
+
+// stringField returns the value of the field of the model in scope that column
+// col is read into. A column of a relation that is not selected is read from
+// the relation's association, if loaded. It reports false if the field is not
+// a string or a nil pointer to one.
+func stringField(scope *gorm.Scope, c *Config, col string) (string, bool, error) {
+	f, ok := scope.FieldByName(resultCol(c, col))
+	if !ok {
+		a, ac, found := relatedStruct(scope, c, col)
+		if found && !a.IsValid() {
+			// Nothing was loaded.
+			return "", false, nil
+		}
+		if found {
+			f, ok = scope.New(a.Interface()).FieldByName(ac)
+		}
+	}
+	if !ok {
+		return "", false, fmt.Errorf("results have no field for search column %q", col)
+	}
+	if f.Field.Kind() == reflect.Ptr && f.Field.IsNil() {
+		return "", false, nil
+	}
+	v, ok := reflect.Indirect(f.Field).Interface().(string)
+	return v, ok, nil
+}
+
+// relatedStruct returns a pointer to the belongs-to or has-one association of
+// the model in scope that the relation of column col joins, and the column's
+// name in it. The pointer is invalid if the association is nil.
+func relatedStruct(scope *gorm.Scope, c *Config, col string) (reflect.Value, string, bool) {
+	r, rc, ok := relationCol(c, col)
+	if !ok {
+		return reflect.Value{}, "", false
+	}
+	name := r
+	for k, rel := range c.Relations {
+		if strings.EqualFold(k, r) && rel.Association != "" {
+			name = rel.Association
+		}
+	}
+	f, ok := scope.FieldByName(name)
+	if !ok {
+		return reflect.Value{}, "", false
+	}
+	v := f.Field
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, rc, true
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, "", false
+	}
+	return v.Addr(), rc, true
+}
+
+// highlight returns the byte ranges of v matched by search term t according to
+// the mode of column sc.
+func highlight(c *Config, sc SearchCol, v, t string) [][2]int {
+	if t == "" {
+		return nil
+	}
+	switch sc.Mode {
+	case SearchExact:
+		if strings.EqualFold(v, t) {
+			return [][2]int{{0, len(v)}}
+		}
+		return nil
+	case SearchPrefix:
+		if end, ok := foldPrefix(v, t); ok {
+			return [][2]int{{0, end}}
+		}
+		return nil
+	case SearchFuzzy:
+		if similarity(v, t) >= fuzzyThreshold(c) {
+			return [][2]int{{0, len(v)}}
+		}
+		return nil
+	case SearchFullText:
+		var ranges [][2]int
+		for _, w := range strings.Fields(t) {
+			ranges = append(ranges, foldIndexAll(v, w)...)
+		}
+		return ranges
+	}
+	// Everything else matches anywhere in the column. For LIKE clauses in
+	// Config.Where, wildcards inside the term match literally here.
+	return foldIndexAll(v, t)
+}
+
+// foldIndexAll returns the byte ranges of all non-overlapping instances of t
+// in s, ignoring case.
+func foldIndexAll(s, t string) [][2]int {
+	var ranges [][2]int
+	for i := 0; i < len(s); {
+		if end, ok := foldPrefix(s[i:], t); ok {
+			ranges = append(ranges, [2]int{i, i + end})
+			i += end
+			continue
+		}
+		_, n := utf8.DecodeRuneInString(s[i:])
+		i += n
+	}
+	return ranges
+}
+
+// foldPrefix reports whether s starts with t, ignoring case, and if so the
+// length in bytes of the prefix of s that matched.
+func foldPrefix(s, t string) (int, bool) {
+	i := 0
+	for _, tr := range t {
+		if i >= len(s) {
+			return 0, false
+		}
+		sr, n := utf8.DecodeRuneInString(s[i:])
+		if !strings.EqualFold(string(sr), string(tr)) {
+			return 0, false
+		}
+		i += n
+	}
+	return i, true
+}
+
+// headlines builds the SELECT expressions requested by Config.Headlines.
+func headlines(c *Config, q *Query) (string, []interface{}, error) {
+	if !c.Headlines || q.Search == "" {
+		return "", nil, nil
+	}
+	if c.dialect != "postgres" {
+		return "", nil, fmt.Errorf("headlines are not supported by dialect %q", c.dialect)
+	}
+	var buf bytes.Buffer
+	var args []interface{}
+
+	term := strings.Join(searchTerms(c, q), " ")
+	for _, sc := range searchCols(c) {
+		pad(&buf, ", ")
+		fmt.Fprintf(&buf, "ts_headline(%s, plainto_tsquery(?)) AS %s_headline", colExpr(c, sc.Name), strings.Replace(sc.Name, ".", "_", -1))
+		args = append(args, term)
+	}
+	return buf.String(), args, nil
+}
@@ -0,0 +1,156 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Histogram requests the counts of matching rows per bucket of a numeric or
+// date column. Exactly one of Interval and Unit must be set.
+type Histogram struct {
+	// Col is the column, which must be whitelisted by Config.HistogramCols.
+	Col string
+
+	// Interval is the width of the buckets of a numeric column. A value v
+	// falls in the bucket that starts at floor(v / Interval) * Interval.
+	Interval float64
+
+	// Unit is the width of the buckets of a date column: "year", "month",
+	// "week", "day" or "hour". Weeks start on Monday.
+	Unit string
+}
+
+// Bucket is the number of matching rows in a bucket of a Histogram.
+type Bucket struct {
+	// Start is where the bucket starts: a number or, for dates, the truncated
+	// date as the database returns it.
+	Start interface{}
+	Count int64
+}
+
+// bucketCol is the name of the computed column of the buckets.
+const bucketCol = "bucket"
+
+// histogramUnits are the units of date histograms.
+var histogramUnits = []string{"year", "month", "week", "day", "hour"}
+
+// histogramCol returns the column of h, as whitelisted by
+// Config.HistogramCols.
+func histogramCol(c *Config, h Histogram) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(h.Col))
+	col := column(c, s)
+	if !contains(c.HistogramCols, col) || hidden(c, col) {
+		return "", fmt.Errorf("query cannot compute histogram of %q", s)
+	}
+	if (h.Interval > 0) == (h.Unit != "") {
+		return "", fmt.Errorf("histogram of %q needs either an interval or a unit", s)
+	}
+	if h.Interval < 0 {
+		return "", fmt.Errorf("invalid histogram interval: %v", h.Interval)
+	}
+	if h.Unit != "" && !contains(histogramUnits, h.Unit) {
+		return "", fmt.Errorf("invalid histogram unit %q", h.Unit)
+	}
+	return col, nil
+}
+
+// histogram counts the rows that match the query in the buckets of h, whose
+// column is col.
+func histogram(db *gorm.DB, c Config, q Query, col string, h Histogram) ([]Bucket, error) {
+	expr := colExpr(&c, col)
+	if h.Unit != "" {
+		expr = truncDate(&c, expr, strings.ToLower(h.Unit))
+	} else {
+		// The interval is part of the expression so that the grouped and
+		// selected expressions are the same.
+		i := strconv.FormatFloat(h.Interval, 'g', -1, 64)
+		expr = floor(&c, fmt.Sprintf("%s * 1.0 / %s", expr, i)) + " * " + i
+	}
+	computed := map[string]ComputedCol{bucketCol: {Expr: expr}}
+	for k, cc := range c.ComputedCols {
+		if !strings.EqualFold(k, bucketCol) {
+			computed[k] = cc
+		}
+	}
+	c.ComputedCols = computed
+	c.GroupableCols = []string{bucketCol}
+	c.Aggregates = map[string][]string{"*": {"count"}}
+	hq := Query{Search: q.Search, WhereArgs: q.WhereArgs, GroupBy: []string{bucketCol}, Aggregates: []string{"count"}}
+	db, err := query(db, &c, &hq)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxHistogramBuckets > 0 {
+		db = db.Limit(c.MaxHistogramBuckets)
+	}
+	rows, err := db.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []Bucket
+	for rows.Next() {
+		var b Bucket
+		if err := rows.Scan(&b.Start, &b.Count); err != nil {
+			return nil, err
+		}
+		if s, ok := b.Start.([]byte); ok {
+			b.Start = string(s)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// floor returns the SQL that rounds expr down to an integer.
+func floor(c *Config, expr string) string {
+	if c.dialect == "sqlite3" {
+		// SQLite only has FLOOR if compiled with its math functions.
+		return fmt.Sprintf("(CAST(%[1]s AS INTEGER) - (%[1]s < CAST(%[1]s AS INTEGER)))", expr)
+	}
+	return "FLOOR(" + expr + ")"
+}
+
+// truncDate returns the SQL that truncates the date expr to unit.
+func truncDate(c *Config, expr, unit string) string {
+	switch c.dialect {
+	case "postgres":
+		return fmt.Sprintf("date_trunc('%s', %s)", unit, expr)
+	case "mysql":
+		switch unit {
+		case "year":
+			return fmt.Sprintf("DATE_FORMAT(%s, '%%Y-01-01')", expr)
+		case "month":
+			return fmt.Sprintf("DATE_FORMAT(%s, '%%Y-%%m-01')", expr)
+		case "week":
+			return fmt.Sprintf("DATE(DATE_SUB(%[1]s, INTERVAL WEEKDAY(%[1]s) DAY))", expr)
+		case "hour":
+			return fmt.Sprintf("DATE_FORMAT(%s, '%%Y-%%m-%%d %%H:00:00')", expr)
+		}
+		return fmt.Sprintf("DATE(%s)", expr)
+	case "mssql":
+		if unit == "week" {
+			// Day 0 is Monday, January 1st 1900.
+			return fmt.Sprintf("DATEADD(day, DATEDIFF(day, 0, %[1]s) / 7 * 7, 0)", expr)
+		}
+		return fmt.Sprintf("DATEADD(%[1]s, DATEDIFF(%[1]s, 0, %[2]s), 0)", unit, expr)
+	}
+	switch unit {
+	case "year":
+		return fmt.Sprintf("strftime('%%Y-01-01', %s)", expr)
+	case "month":
+		return fmt.Sprintf("strftime('%%Y-%%m-01', %s)", expr)
+	case "week":
+		return fmt.Sprintf("date(%s, 'weekday 0', '-6 days')", expr)
+	case "hour":
+		return fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:00:00', %s)", expr)
+	}
+	return fmt.Sprintf("date(%s)", expr)
+}
@@ -0,0 +1,209 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// includes returns the association paths to preload for Query.Include, as
+// named in Config.PreloadableAssociations.
+func includes(c *Config, q *Query) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+
+Outer:
+	for _, inc := range q.Include {
+		segs := strings.Split(strings.TrimSpace(inc), ".")
+		if segs[0] == "" {
+			// We got an empty include. Nothing to do.
+			continue
+		}
+		if c.MaxIncludeDepth > 0 && len(segs) > c.MaxIncludeDepth {
+			return nil, fmt.Errorf("include %q is deeper than %d", inc, c.MaxIncludeDepth)
+		}
+		for _, pa := range c.PreloadableAssociations {
+			// Including a whitelisted path includes its ancestors anyway, so
+			// they are allowed as well. They are listed explicitly, ancestors
+			// first, so each can have its own Fields.
+			psegs := strings.Split(pa, ".")
+			if len(segs) > len(psegs) || !sameAssociations(segs, psegs) {
+				continue
+			}
+			for i := range segs {
+				p := strings.Join(psegs[:i+1], ".")
+				if !seen[p] {
+					seen[p] = true
+					paths = append(paths, p)
+				}
+			}
+			continue Outer
+		}
+		return nil, fmt.Errorf("query cannot include %q", inc)
+	}
+	return paths, nil
+}
+
+// sameAssociations reports whether the association names in segs match the
+// first ones in psegs, ignoring case and accepting their snake case.
+func sameAssociations(segs, psegs []string) bool {
+	for i, s := range segs {
+		if !strings.EqualFold(s, psegs[i]) && !strings.EqualFold(s, gorm.ToColumnName(psegs[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// includeLimit returns the condition that keeps, when preloading the has-many
+// association path, the first records of each row by primary key, as many as
+// Config.IncludeLimits allows. It returns an empty condition if there is no
+// limit.
+func includeLimit(db *gorm.DB, c *Config, path string) (string, error) {
+	limit, ok := c.IncludeLimits[path]
+	if !ok {
+		return "", nil
+	}
+	if c.model == nil {
+		return "", fmt.Errorf("include limit of %q needs a model", path)
+	}
+	f, ok := association(db, c.model, path)
+	if !ok || f.Relationship.Kind != "has_many" {
+		return "", fmt.Errorf("include limit of %q needs a has-many association", path)
+	}
+	scope := db.New().NewScope(reflect.New(indirectType(f.Struct.Type)).Interface())
+	table, pk := scope.TableName(), scope.PrimaryKey()
+	if pk == "" {
+		return "", fmt.Errorf("include limit of %q needs a primary key", path)
+	}
+
+	// The records of the same row that come before this one.
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "(SELECT COUNT(*) FROM %s AS include_rank WHERE ", table)
+	for _, k := range f.Relationship.ForeignDBNames {
+		fmt.Fprintf(&buf, "include_rank.%[1]s = %[2]s.%[1]s AND ", k, table)
+	}
+	fmt.Fprintf(&buf, "include_rank.%[1]s < %[2]s.%[1]s) < %[3]d", pk, table, limit)
+	return buf.String(), nil
+}
+
+// includeFields returns the columns to select when preloading path, as
+// requested by Query.Fields. It returns nil if the query did not restrict them.
+// The keys needed to match the association to its parent, and to the
+// associations included beneath it, are always selected.
+func includeFields(db *gorm.DB, c *Config, q *Query, path string, paths []string) ([]string, error) {
+	var requested []string
+	for k, v := range q.Fields {
+		segs := strings.Split(strings.TrimSpace(k), ".")
+		psegs := strings.Split(path, ".")
+		if len(segs) == len(psegs) && sameAssociations(segs, psegs) {
+			for _, f := range v {
+				requested = append(requested, strings.Split(f, ",")...)
+			}
+		}
+	}
+
+	var cols []string
+	seen := make(map[string]bool)
+	add := func(col string) {
+		if !seen[col] {
+			seen[col] = true
+			cols = append(cols, col)
+		}
+	}
+	selectable := c.PreloadSelectableCols[path]
+Outer:
+	for _, r := range requested {
+		col := strings.ToLower(strings.TrimSpace(r))
+		if col == "" {
+			continue
+		}
+		if len(selectable) == 0 {
+			add(col)
+			continue
+		}
+		for _, sc := range selectable {
+			if strings.EqualFold(col, sc) {
+				add(col)
+				continue Outer
+			}
+		}
+		return nil, fmt.Errorf("query cannot select column %q of %q", col, path)
+	}
+	if len(cols) == 0 || c.model == nil {
+		return cols, nil
+	}
+
+	// Add the keys.
+	f, ok := association(db, c.model, path)
+	if !ok {
+		return cols, nil
+	}
+	rel := f.Relationship
+	if rel.Kind == "belongs_to" {
+		for _, k := range rel.AssociationForeignDBNames {
+			add(k)
+		}
+	} else {
+		for _, k := range rel.ForeignDBNames {
+			add(k)
+		}
+	}
+	for _, pf := range db.NewScope(reflect.New(indirectType(f.Struct.Type)).Interface()).PrimaryFields() {
+		add(pf.DBName)
+	}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, path+".") || strings.Contains(p[len(path)+1:], ".") {
+			continue
+		}
+		child, ok := association(db, c.model, p)
+		if !ok {
+			continue
+		}
+		if child.Relationship.Kind == "belongs_to" {
+			for _, k := range child.Relationship.ForeignDBNames {
+				add(k)
+			}
+		} else {
+			for _, k := range child.Relationship.AssociationForeignDBNames {
+				add(k)
+			}
+		}
+	}
+	return cols, nil
+}
+
+// association returns the field of the association at path, starting from
+// model.
+func association(db *gorm.DB, model interface{}, path string) (*gorm.StructField, bool) {
+	var field *gorm.StructField
+	for _, name := range strings.Split(path, ".") {
+		var found bool
+		for _, f := range db.NewScope(model).GetModelStruct().StructFields {
+			if f.Name == name && f.Relationship != nil {
+				field, found = f, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		model = reflect.New(indirectType(field.Struct.Type)).Interface()
+	}
+	return field, field != nil
+}
+
+// indirectType returns the type of the elements of slices and pointers in
+// typ.
+func indirectType(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Slice || typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
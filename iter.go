@@ -0,0 +1,116 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// All returns an iterator over the results of all pages of the query, starting
+// at its Page, or the first one if it has none. Pages are fetched as the
+// iteration needs them. If the iteration fails, or ctx is done, the error is
+// yielded with the zero T and the iteration stops.
+//
+// If the query orders by nothing but Config.TiebreakerCol, pages after the
+// first continue after the last row of the previous page instead of skipping
+// rows, so rows inserted or deleted meanwhile do not make others be skipped or
+// repeat. Otherwise pages are fetched by number.
+func All[T any](ctx context.Context, db *gorm.DB, c Config, q Query) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		if q.Page == 0 {
+			q.Page = 1
+		}
+		size := int(pageSize(&c, &q))
+		keyset, desc := keysetOrder(&c, &q)
+		filter := c.FilterFunc
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			var items []T
+			res, err := Do(db, c, q, &items)
+			if err == nil {
+				err = res.Error
+			}
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if len(items) < size {
+				return
+			}
+			if !keyset {
+				q.Page++
+				continue
+			}
+			f, ok := db.NewScope(&items[len(items)-1]).FieldByName(c.TiebreakerCol)
+			if !ok {
+				yield(zero, fmt.Errorf("results have no field for tiebreaker column %q", c.TiebreakerCol))
+				return
+			}
+			col := db.NewScope(&items[0]).TableName() + "." + strings.ToLower(c.TiebreakerCol)
+			c.FilterFunc = after(filter, col, f.Field.Interface(), desc)
+			q.Page = 1
+		}
+	}
+}
+
+// keysetOrder reports whether the query orders by Config.TiebreakerCol alone
+// and if so, whether in descending order. With Config.DistinctOn it never does,
+// since Postgres orders by those columns first.
+func keysetOrder(c *Config, q *Query) (ok bool, desc bool) {
+	if c.TiebreakerCol == "" || grouping(q) || distinctOn(c) != "" {
+		return false, false
+	}
+	obs := q.OrderBy
+	if blank(obs) {
+		obs = c.DefaultOrderBy
+	}
+	var orders []order
+	for _, o := range orderEntries(c, obs) {
+		ob, ok, err := parseOrder(c, o)
+		if err != nil {
+			return false, false
+		}
+		if ok {
+			orders = append(orders, ob)
+		}
+	}
+	switch {
+	case len(orders) == 0:
+		// The tiebreaker is the order.
+		return true, false
+	case len(orders) == 1 && strings.EqualFold(column(c, orders[0].col), c.TiebreakerCol):
+		return true, orders[0].dir == "desc"
+	}
+	return false, false
+}
+
+// after returns a FilterFunc that applies filter, if any, and keeps the rows
+// whose column col comes after last.
+func after(filter func(*gorm.DB, Query) *gorm.DB, col string, last interface{}, desc bool) func(*gorm.DB, Query) *gorm.DB {
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	cond := fmt.Sprintf("%s %s ?", col, op)
+	return func(db *gorm.DB, q Query) *gorm.DB {
+		if filter != nil {
+			db = filter(db, q)
+		}
+		return db.Where(cond, last)
+	}
+}
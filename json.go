@@ -0,0 +1,54 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// jsonPath splits name into JSON column and path if name is "column.path" and
+// the path is in Config.JSONPaths for the column, e.g. "attrs.size.width". The
+// path is returned as configured, since JSON keys are case sensitive. Paths of
+// hidden columns are hidden as well.
+func jsonPath(c *Config, name string) (string, string, bool) {
+	i := strings.Index(name, ".")
+	if i < 0 {
+		return "", "", false
+	}
+	if _, _, ok := relationCol(c, name); ok {
+		return "", "", false
+	}
+	for col, paths := range c.JSONPaths {
+		if !strings.EqualFold(col, name[:i]) || hidden(c, col) {
+			continue
+		}
+		for _, p := range paths {
+			if strings.EqualFold(p, name[i+1:]) {
+				return strings.ToLower(col), p, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// jsonExpr returns the SQL that extracts path from JSON column col as text.
+func jsonExpr(c *Config, col, path string) string {
+	if c.table != "" {
+		col = c.table + "." + col
+	}
+	keys := strings.Split(path, ".")
+	switch c.dialect {
+	case "postgres":
+		if len(keys) == 1 {
+			return fmt.Sprintf("%s->>'%s'", col, path)
+		}
+		return fmt.Sprintf("%s#>>'{%s}'", col, strings.Join(keys, ","))
+	case "mysql":
+		return fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(%s, '$.%s'))", col, path)
+	case "mssql":
+		return fmt.Sprintf("JSON_VALUE(%s, '$.%s')", col, path)
+	}
+	return fmt.Sprintf("json_extract(%s, '$.%s')", col, path)
+}
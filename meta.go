@@ -0,0 +1,212 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Meta describes the rows a query matched, beyond the page of results.
+type Meta struct {
+	// Facets maps the columns in Query.Facets to the counts of their values,
+	// most frequent first.
+	Facets map[string][]FacetCount
+
+	// Histograms are the buckets of the Query.Histograms, in the same order.
+	Histograms [][]Bucket
+
+	// Summary maps the names of the Query.Summary aggregates, e.g.
+	// "sum_amount", to their values over all matching rows.
+	Summary map[string]interface{}
+
+	// Total is the number of rows that match the query if Query.CountTotal is
+	// set.
+	Total int64
+}
+
+// FacetCount is the number of matching rows with a value of a facet column.
+type FacetCount struct {
+	Value interface{}
+	Count int64
+}
+
+// DoWithMeta is like Do, but also returns the Meta of the rows the query
+// matched. Unlike Do, errors of the queries that compute the Meta are returned
+// as errors. The Meta is nil if the query of the results failed. Every query
+// calls Config.FilterFunc with q, though those of the Meta group or count the
+// rows instead of paginating them.
+func DoWithMeta(db *gorm.DB, c Config, q Query, results interface{}) (*gorm.DB, *Meta, error) {
+	cols, err := facetCols(&c, &q)
+	if err != nil {
+		return nil, nil, err
+	}
+	var hcols []string
+	for _, h := range q.Histograms {
+		col, err := histogramCol(&c, h)
+		if err != nil {
+			return nil, nil, err
+		}
+		hcols = append(hcols, col)
+	}
+	if _, err := aggregates(&c, &Query{Aggregates: q.Summary}); err != nil {
+		return nil, nil, err
+	}
+	res, err := Do(db, c, q, results)
+	if err != nil || res.Error != nil {
+		return res, nil, err
+	}
+
+	c.dialect = db.Dialect().GetName()
+	c.model = results
+	c.filterQuery = &q
+	m := &Meta{}
+	for i, col := range cols {
+		fc, err := facet(db.Model(results), c, q, col)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m.Facets == nil {
+			m.Facets = make(map[string][]FacetCount)
+		}
+		m.Facets[strings.ToLower(strings.TrimSpace(q.Facets[i]))] = fc
+	}
+	for i, col := range hcols {
+		b, err := histogram(db.Model(results), c, q, col, q.Histograms[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		m.Histograms = append(m.Histograms, b)
+	}
+	if q.CountTotal {
+		m.Total, err = total(db, c, q, results)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if !blank(q.Summary) {
+		m.Summary, err = summary(db.Model(results), c, q)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return res, m, nil
+}
+
+// facetCols returns the columns in Query.Facets, as whitelisted by
+// Config.FacetCols.
+func facetCols(c *Config, q *Query) ([]string, error) {
+	var cols []string
+	for _, f := range q.Facets {
+		s := strings.ToLower(strings.TrimSpace(f))
+		col := column(c, s)
+		if !contains(c.FacetCols, col) || hidden(c, col) {
+			return nil, fmt.Errorf("query cannot count facet %q", s)
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// facet counts the values of column col in the rows that match the query. If
+// Config.FacetsExcludeOwnFilter is set, the WhereArgs on col are left out.
+func facet(db *gorm.DB, c Config, q Query, col string) ([]FacetCount, error) {
+	fq := Query{Search: q.Search, WhereArgs: q.WhereArgs}
+	if c.FacetsExcludeOwnFilter {
+		fq.WhereArgs = make(map[string]interface{})
+		for k, v := range q.WhereArgs {
+			if column(&c, strings.ToLower(strings.TrimSpace(k))) != col {
+				fq.WhereArgs[k] = v
+			}
+		}
+	}
+	fq.GroupBy = []string{col}
+	fq.Aggregates = []string{"count"}
+	fq.OrderBy = []string{"count desc"}
+	c.GroupableCols = []string{col}
+	c.Aggregates = map[string][]string{"*": {"count"}}
+	db, err := query(db, &c, &fq)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxFacetValues > 0 {
+		db = db.Limit(c.MaxFacetValues)
+	}
+	rows, err := db.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []FacetCount
+	for rows.Next() {
+		var fc FacetCount
+		if err := rows.Scan(&fc.Value, &fc.Count); err != nil {
+			return nil, err
+		}
+		if b, ok := fc.Value.([]byte); ok {
+			fc.Value = string(b)
+		}
+		counts = append(counts, fc)
+	}
+	return counts, rows.Err()
+}
+
+// summary computes the Query.Summary aggregates over all rows that match the
+// query, in one query.
+func summary(db *gorm.DB, c Config, q Query) (map[string]interface{}, error) {
+	sq := Query{Search: q.Search, WhereArgs: q.WhereArgs, Aggregates: q.Summary}
+	aggs, err := aggregates(&c, &sq)
+	if err != nil {
+		return nil, err
+	}
+	db, err = query(db, &c, &sq)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make([]interface{}, len(aggs))
+	ptrs := make([]interface{}, len(aggs))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	sum := make(map[string]interface{})
+	if rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, a := range aggs {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			sum[a.name] = values[i]
+		}
+	}
+	return sum, rows.Err()
+}
+
+// total counts the rows that match the query by counting the rows of the query
+// without pagination, order or included associations.
+func total(db *gorm.DB, c Config, q Query, results interface{}) (int64, error) {
+	if fuzzyInMemory(&c, &q) {
+		return 0, fmt.Errorf("query cannot count rows matched by a fuzzy search")
+	}
+	tq := q
+	tq.Include, tq.Fields = nil, nil
+	c.unordered = true
+	tdb, err := query(db.Model(results), &c, &tq)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	err = db.Raw("SELECT COUNT(*) FROM ? AS counted", tdb.SubQuery()).Row().Scan(&n)
+	return n, err
+}
@@ -0,0 +1,151 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NullsOrder describes where NULLs sort relative to other values.
+type NullsOrder int
+
+const (
+	// NullsDefault leaves it to the database, which disagree: Postgres sorts
+	// NULLs as larger than any value, SQLite and MySQL as smaller.
+	NullsDefault NullsOrder = iota
+	// NullsFirst sorts NULLs before any value, whatever the direction.
+	NullsFirst
+	// NullsLast sorts NULLs after any value, whatever the direction.
+	NullsLast
+)
+
+// OrderSyntax is a set of syntaxes accepted in OrderBy besides "col [dir]".
+// Whatever the syntax, entries are validated and rendered the same way.
+type OrderSyntax uint
+
+const (
+	// OrderSigned accepts a leading "-" for descending order and "+" for
+	// ascending order, e.g. "-created_at".
+	OrderSigned OrderSyntax = 1 << iota
+	// OrderColon accepts the direction after a colon, e.g. "created_at:desc".
+	OrderColon
+	// OrderCommaList accepts several comma-separated entries in one OrderBy
+	// string, e.g. "created_at desc, name".
+	OrderCommaList
+
+	// OrderJSONAPI accepts the sort parameter of JSON:API, e.g.
+	// "-created_at,name".
+	OrderJSONAPI = OrderSigned | OrderCommaList
+)
+
+// orderEntries returns the entries in obs, splitting comma-separated lists if
+// the Config accepts them.
+func orderEntries(c *Config, obs []string) []string {
+	if c.OrderSyntaxes&OrderCommaList == 0 {
+		return obs
+	}
+	var entries []string
+	for _, o := range obs {
+		entries = append(entries, strings.Split(o, ",")...)
+	}
+	return entries
+}
+
+// order is a parsed OrderBy entry.
+type order struct {
+	col   string
+	dir   string // "asc", "desc" or empty.
+	nulls NullsOrder
+}
+
+// parseOrder parses an OrderBy entry of the form "col [asc|desc] [nulls
+// first|last]", ignoring case, or any of the other syntaxes the Config accepts.
+// It reports false if o is empty.
+func parseOrder(c *Config, o string) (order, bool, error) {
+	oo := strings.ToLower(strings.TrimSpace(o))
+	if c.OrderSyntaxes&OrderSigned != 0 && oo != "" && (oo[0] == '-' || oo[0] == '+') {
+		// Turn "-col" into "col desc" and "+col" into "col asc".
+		dir := "asc"
+		if oo[0] == '-' {
+			dir = "desc"
+		}
+		ob := strings.SplitN(oo[1:], " ", 2)
+		ob = append(ob[:1], append([]string{dir}, ob[1:]...)...)
+		oo = strings.Join(ob, " ")
+	}
+	if c.OrderSyntaxes&OrderColon != 0 {
+		oo = strings.Replace(oo, ":", " ", 1)
+	}
+	ob := strings.Fields(oo)
+	if len(ob) == 0 {
+		return order{}, false, nil
+	}
+	r := order{col: ob[0]}
+	rest := ob[1:]
+	if len(rest) == 1 || len(rest) == 3 {
+		if rest[0] != "asc" && rest[0] != "desc" {
+			return order{}, false, fmt.Errorf("invalid sort direction in order_by clause %q", o)
+		}
+		r.dir = rest[0]
+		rest = rest[1:]
+	}
+	if len(rest) == 2 && rest[0] == "nulls" {
+		switch rest[1] {
+		case "first":
+			r.nulls = NullsFirst
+		case "last":
+			r.nulls = NullsLast
+		default:
+			return order{}, false, fmt.Errorf("invalid nulls order in order_by clause %q", o)
+		}
+		rest = nil
+	}
+	if len(rest) != 0 {
+		return order{}, false, fmt.Errorf("invalid order_by clause %q", o)
+	}
+	return r, true, nil
+}
+
+// nullsOrder returns where NULLs of column col sort according to Config if the
+// query did not say.
+func nullsOrder(c *Config, col string) NullsOrder {
+	for k, v := range c.NullsOrder {
+		if strings.EqualFold(k, col) {
+			return v
+		}
+	}
+	return NullsDefault
+}
+
+// orderTerm renders o as ORDER BY terms for expression expr. Postgres sorts
+// NULLs natively, other databases sort by whether expr is NULL first.
+func orderTerm(c *Config, expr string, o order) string {
+	t := expr
+	if o.dir != "" {
+		t += " " + o.dir
+	}
+	nulls := o.nulls
+	if nulls == NullsDefault {
+		nulls = nullsOrder(c, o.col)
+	}
+	if nulls == NullsDefault {
+		return t
+	}
+	if c.dialect == "postgres" {
+		if nulls == NullsFirst {
+			return t + " nulls first"
+		}
+		return t + " nulls last"
+	}
+	isNull := expr + " is null"
+	if c.dialect == "mssql" {
+		// SQL Server cannot order by a boolean.
+		isNull = "case when " + expr + " is null then 1 else 0 end"
+	}
+	if nulls == NullsFirst {
+		return isNull + " desc, " + t
+	}
+	return isNull + ", " + t
+}
@@ -0,0 +1,38 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Page is a page of results of type T.
+type Page[T any] struct {
+	// Items are the results on the page.
+	Items []T
+
+	// Page is the number of the page, starting at 1.
+	Page uint32
+
+	// PageSize is the page size used, after Config's defaults and limits.
+	PageSize uint16
+
+	// Meta describes the rows the query matched, as DoWithMeta does.
+	Meta *Meta
+}
+
+// Find performs the querying and pagination as described by Query, subject to
+// the constraints of Config, and returns the page of results. Unlike Do, it
+// returns the database's errors as its own, so there is nothing else to check.
+func Find[T any](db *gorm.DB, c Config, q Query) (Page[T], error) {
+	var items []T
+	res, m, err := DoWithMeta(db, c, q, &items)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	if res.Error != nil {
+		return Page[T]{}, res.Error
+	}
+	return Page[T]{Items: items, Page: q.Page, PageSize: pageSize(&c, &q), Meta: m}, nil
+}
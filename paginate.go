@@ -16,8 +16,65 @@ type Config struct {
 	// no restrictions.
 	SelectableCols []string
 
+	// HiddenCols can never be selected, filtered, searched or ordered by, even
+	// if other fields of Config allow it. E.g. {"password_hash", "ssn"}.
+	HiddenCols []string
+
+	// DefaultExcludedCols are only selected if the Query's Select asks for them
+	// by name. Selecting everything leaves them out.
+	DefaultExcludedCols []string
+
+	// GroupableCols is a list of all columns that can be grouped by.
+	GroupableCols []string
+
+	// Aggregates maps columns to the aggregate functions that can be applied to
+	// them when grouping or in a Query.Summary: "count", "sum", "avg", "min" or
+	// "max". The column "*" allows counting rows, e.g. {"*": {"count"},
+	// "total": {"sum", "avg"}}.
+	Aggregates map[string][]string
+
+	// FacetCols is a list of all columns whose values can be counted by
+	// DoWithMeta, as requested by Query.Facets.
+	FacetCols []string
+
+	// FacetsExcludeOwnFilter leaves the WhereArgs on a facet column out of the
+	// count of its values, so that the counts show what choosing another value
+	// would match.
+	FacetsExcludeOwnFilter bool
+
+	// MaxFacetValues is the maximum number of values counted per facet, the
+	// most frequent ones. Zero means no limit.
+	MaxFacetValues int
+
+	// HistogramCols is a list of all numeric and date columns whose values can
+	// be counted in buckets by DoWithMeta, as requested by Query.Histograms.
+	HistogramCols []string
+
+	// MaxHistogramBuckets is the maximum number of buckets per histogram, the
+	// lowest ones. Zero means no limit.
+	MaxHistogramBuckets int
+
+	// Distinct removes duplicate rows, e.g. those repeated by the joins of a
+	// FilterFunc, as SELECT DISTINCT does. On Postgres, the columns ordered by
+	// must then be selected.
+	Distinct bool
+
+	// DistinctOn lists columns whose values identify duplicate rows. Only the
+	// first row of each group, in the order of the query, is kept. Postgres
+	// uses DISTINCT ON, which sorts the rows by these columns first. Other
+	// dialects use a window function over the rows, which needs a model with a
+	// primary key. DistinctOn takes precedence over Distinct.
+	DistinctOn []string
+
+	// MaxExportRows is the maximum number of rows that Stream will stream,
+	// regardless of MaxPageSize. If MaxExportRows is not set, it defaults to
+	// maxExportRows.
+	MaxExportRows int
+
 	// FilterFunc pre-configures the query in a way that expands or restricts
-	// the query. It is applied *before* the final GORM query is built.
+	// the query. It is applied *before* the final GORM query is built. It is
+	// called with the caller's Query, also for the queries that DoWithMeta
+	// makes to compute the Meta.
 	FilterFunc func(db *gorm.DB, query Query) *gorm.DB
 
 	// MaxPageSize is the maximum number of elements a query can request in one
@@ -29,9 +86,85 @@ type Config struct {
 	// default (defaultPageSize) is used.
 	DefaultPageSize uint16
 
+	// Relations declares tables related to the queried one, which are joined
+	// when a query uses their columns. Their columns are named
+	// "relation.column" in SelectableCols, Where, OrderableCols and the rest of
+	// Config. E.g. with a "customer" relation, Where may have
+	// {"customer.country": "= ?"}.
+	Relations map[string]Relation
+
+	// PreloadableAssociations lists the GORM associations that the Query may
+	// Include, e.g. {"Items", "Customer.Address"}. Including a nested
+	// association such as "customer.address" preloads its parents too, so they
+	// may be included on their own as well.
+	PreloadableAssociations []string
+
+	// MaxIncludeDepth is the maximum number of associations in an include path,
+	// e.g. 1 allows "customer" but not "customer.address". Zero means no limit
+	// besides PreloadableAssociations.
+	MaxIncludeDepth int
+
+	// PreloadSelectableCols restricts which columns the Query's Fields may
+	// select for each association, named as in PreloadableAssociations. An
+	// association without an entry has no restrictions.
+	PreloadSelectableCols map[string][]string
+
+	// IncludeLimits caps the number of records preloaded per row for the given
+	// has-many associations, named as in PreloadableAssociations. E.g.
+	// {"Items": 10} preloads the first 10 items of each order by primary key.
+	// The database only returns those.
+	IncludeLimits map[string]int
+
+	// Aliases maps public names to column names, e.g. {"createdAt":
+	// "created_at"}. Queries may use the public names in Select, WhereArgs and
+	// OrderBy and errors report them, but SQL and the rest of Config use the
+	// column names. Columns without an alias keep their name.
+	Aliases map[string]string
+
+	// Expressions maps names to server-defined SQL expressions, e.g.
+	// {"full_name": "first_name || ' ' || last_name", "email": "LOWER(email)"}.
+	// Wherever SelectableCols, Where or OrderableCols name one of them, the SQL
+	// uses the expression instead, so queries may select, filter and order by
+	// full_name as if it were a column. A selected expression is named after its
+	// key ("... AS full_name").
+	Expressions map[string]string
+
+	// JSONPaths lists, for JSON columns, the paths into them that queries may
+	// select, filter and order by as "column.path", e.g. {"attrs": {"color",
+	// "size.width"}} allows "attrs.size.width". Paths compare as text, with
+	// "= ?" unless Where names them with another clause, and are selected as
+	// "column_path" with dots turned into underscores. A relation of the same
+	// name as a JSON column takes precedence.
+	JSONPaths map[string][]string
+
+	// ComputedCols are named columns computed by the database. Unlike
+	// Expressions, they need not be listed elsewhere: they are always
+	// selectable, and selected unless the Query's Select leaves them out. E.g.
+	// {"total_with_tax": {Expr: "total * 1.2", Where: "> ?", Orderable: true}}.
+	ComputedCols map[string]ComputedCol
+
 	// OrderableCols is a list of all columns that can be ordered by.
 	OrderableCols []string
 
+	// OrderSyntaxes are the syntaxes accepted in the OrderBy of the Query besides
+	// "col [dir]". E.g. OrderJSONAPI accepts "-created_at,name".
+	OrderSyntaxes OrderSyntax
+
+	// DefaultOrderBy is the order used when the Query does not have an OrderBy.
+	// Its columns need not be in OrderableCols.
+	DefaultOrderBy []string
+
+	// TiebreakerCol is a unique column, typically the primary key, added to the
+	// end of every order that does not already include it. Without a unique
+	// order, the database may return rows that compare equal in any order, so
+	// they could repeat or go missing across pages.
+	TiebreakerCol string
+
+	// NullsOrder sets where NULLs sort for the given orderable columns when the
+	// OrderBy in the Query does not say. E.g. {"last_login": NullsLast} sorts
+	// users that never logged in last, whatever the direction.
+	NullsOrder map[string]NullsOrder
+
 	// Where describes the possible where clauses that are optionally
 	// matched against WhereArgs in the Query.
 	// E.g. {"id": "> ?", "doc_age": "< ?"} would match with WhereArgs
@@ -41,6 +174,90 @@ type Config struct {
 	// DisallowSearchTerm ignores the Search parameter in the Query. By default,
 	// search is allowed.
 	DisallowSearchTerm bool
+
+	// SearchCols lists the columns searched by the Search parameter in the Query
+	// and how each one matches it. If SearchCols is empty, the searched columns
+	// are the Where entries that contain a LIKE clause, matched by the LIKE
+	// clause itself.
+	SearchCols []SearchCol
+
+	// FullTextSearch matches the Search parameter in the Query using the
+	// database's full-text engine instead of the LIKE clauses themselves. The
+	// searched columns are still the Where entries that contain a LIKE clause.
+	// Only Postgres and MySQL support it. It is ignored if SearchCols is set;
+	// use SearchFullText instead.
+	FullTextSearch bool
+
+	// TokenizeSearch splits the Search parameter in the Query into tokens
+	// separated by white space and requires every token to match at least one
+	// searched column, so "john smith" finds John in first_name and Smith in
+	// last_name. Quoted text ("van der") is a single token and tokens preceded by
+	// "-" must not match any column. Unless a token already has "%" wildcards,
+	// LIKE clauses match it anywhere in the column.
+	TokenizeSearch bool
+
+	// MaxSearchTokens is the maximum number of tokens a tokenized Search may
+	// have. If MaxSearchTokens is not set, it defaults to maxSearchTokens.
+	MaxSearchTokens int
+
+	// SearchWeights weighs the columns searched by the Search parameter when
+	// ordering by RelevanceKey. E.g. {"name": 2} makes a match on name count
+	// twice as much as a match on any other column. Columns not present weigh 1.
+	// It is ignored if SearchCols is set; use SearchCol.Weight instead.
+	SearchWeights map[string]float64
+
+	// FuzzyThreshold is the minimum similarity, between 0 and 1, of a column to
+	// the Search parameter for SearchFuzzy columns to match. If FuzzyThreshold
+	// is not set, it defaults to defaultFuzzyThreshold.
+	FuzzyThreshold float64
+
+	// MaxFuzzyRows is the maximum number of rows read when SearchFuzzy columns
+	// must be matched in Go, which is the case for every database but Postgres.
+	// All rows matching the rest of the query are read, up to MaxFuzzyRows, then
+	// the Search parameter is matched and the results paginated in memory. If
+	// MaxFuzzyRows is not set, it defaults to maxFuzzyRows.
+	MaxFuzzyRows int
+
+	// Headlines selects a snippet of each searched column with the Search
+	// parameter highlighted, as computed by Postgres' ts_headline. The snippet
+	// of column "bio" is selected as "bio_headline", so it can be read into a
+	// model field such as BioHeadline. Only Postgres supports it; see Highlight
+	// for other databases.
+	Headlines bool
+
+	// dialect is the name of the GORM dialect the query is built for.
+	dialect string
+
+	// model is what the results are read into, if known.
+	model interface{}
+
+	// table is the name of the queried table if columns must be qualified.
+	table string
+
+	// modelCols are the columns of the model, if they must be named.
+	modelCols []string
+
+	// unordered leaves out the ORDER BY, for queries whose order is moot.
+	unordered bool
+
+	// filterQuery is the Query that FilterFunc is called with, if not the
+	// Query being built, e.g. the caller's for the queries of DoWithMeta.
+	filterQuery *Query
+}
+
+// ComputedCol is a column computed by a SQL expression. See
+// Config.ComputedCols.
+type ComputedCol struct {
+	// Expr is the SQL expression, e.g. "CASE WHEN age < 18 THEN 'minor' ELSE
+	// 'adult' END".
+	Expr string
+
+	// Where is the clause to filter the column by, as in Config.Where, e.g.
+	// "= ?". The column cannot be filtered by if it is empty.
+	Where string
+
+	// Orderable reports whether the column can be ordered by.
+	Orderable bool
 }
 
 // Query declares a query instance, used for querying a model subject to the
@@ -67,14 +284,63 @@ type Query struct {
 	Page uint32
 
 	// OrderBy describes the columns to order by and optionally the mode ("ASC"
-	// or "DESC"). If OrderBy is not whitelisted by Config.OrderableCols, an
-	// error is returned.
+	// or "DESC") followed by where NULLs sort ("NULLS FIRST" or "NULLS LAST").
+	// If OrderBy is not whitelisted by Config.OrderableCols, an error is
+	// returned. RelevanceKey orders by how well rows match Search.
 	OrderBy []string
 
-	// Search is a string term that is applied to *all* Config.Where entries that
-	// contain a LIKE clause. If Search is present, all WhereArgs that map to a LIKE
-	// will have Search applied to them in an OR fashion. This means that a configuration
-	// such as
+	// GroupBy lists the columns to group rows by. Grouped rows are selected,
+	// filtered, ordered and paginated like rows, but only the grouped columns
+	// and Aggregates can be selected and ordered by, and Config.DefaultOrderBy
+	// does not apply. If a column is not whitelisted by Config.GroupableCols,
+	// an error is returned.
+	GroupBy []string
+
+	// Aggregates lists the aggregates to compute, e.g. {"count(*)",
+	// "sum(total)"}. They are selected, and can be ordered by, as "count" and
+	// "sum_total". Without GroupBy, all rows are aggregated into one. If an
+	// aggregate is not whitelisted by Config.Aggregates, an error is returned.
+	Aggregates []string
+
+	// Facets lists the columns whose values DoWithMeta counts in the rows
+	// matching WhereArgs and Search, e.g. {"status"}. If a column is not
+	// whitelisted by Config.FacetCols, an error is returned.
+	Facets []string
+
+	// Histograms lists the histograms that DoWithMeta computes over the rows
+	// matching WhereArgs and Search, e.g. {{Col: "price", Interval: 10}} or
+	// {{Col: "created_at", Unit: "month"}}.
+	Histograms []Histogram
+
+	// Summary lists the aggregates that DoWithMeta computes over all rows
+	// matching WhereArgs and Search, regardless of the page, e.g.
+	// {"sum(amount)", "avg(amount)"}. They are named and whitelisted as in
+	// Aggregates.
+	Summary []string
+
+	// CountTotal asks DoWithMeta for the number of rows that match the query
+	// over all pages, counted like the rows of the page.
+	CountTotal bool
+
+	// Include lists the associations to preload with the results, e.g.
+	// {"items", "customer.address"}. Associations are named as in
+	// Config.PreloadableAssociations, ignoring case, or in snake case. If an
+	// association is not whitelisted by Config.PreloadableAssociations, an error
+	// is returned.
+	Include []string
+
+	// Fields restricts the columns selected for included associations, keyed by
+	// the association as in Include. E.g. {"customer": {"name", "email"}}, which
+	// may also be given as {"customer": {"name,email"}}, much like JSON:API's
+	// fields[customer]=name,email. The keys that link associations are always
+	// selected. If a column is not whitelisted by Config.PreloadSelectableCols,
+	// an error is returned. Fields of associations not included are ignored.
+	Fields map[string][]string
+
+	// Search is a string term that is applied to *all* Config.SearchCols or, if
+	// there are none, to *all* Config.Where entries that contain a LIKE clause. If
+	// Search is present, all WhereArgs that map to a LIKE will have Search applied
+	// to them in an OR fashion. This means that a configuration such as
 	// Config{Where: {"first_name":"like ?", "last_name":"like ?"}} when matched with
 	// Query{Search:"august"}
 	// would emit "WHERE first_name LIKE august OR last_name LIKE august"
@@ -92,6 +358,11 @@ const (
 // An error-less return does not mean the query succeeded, it only means the
 // query builder succeeded -- one must also check the Error field in gorm.DB.
 func Do(db *gorm.DB, c Config, q Query, results interface{}) (*gorm.DB, error) {
+	c.dialect = db.Dialect().GetName()
+	c.model = results
+	if fuzzyInMemory(&c, &q) {
+		return fuzzy(db, &c, &q, results)
+	}
 	var err error
 	db, err = build(db, &c, &q)
 	if err != nil {
@@ -0,0 +1,145 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Relation declares a table related to the queried one. Its columns may be
+// used wherever Config names a column as "relation.column", where relation is
+// the name of the Relation in Config.Relations. The relation is only joined if
+// the query uses one of its columns.
+type Relation struct {
+	// Join is the SQL that joins the relation. It must name the related table
+	// after the relation, e.g. Relations{"customer": {Join: "JOIN customers AS
+	// customer ON customer.id = orders.customer_id"}}.
+	Join string
+
+	// Association is the name of a belongs-to, has-one or has-many association
+	// of the queried model, e.g. "Customer". It is joined on the association's
+	// keys if Join is empty. Beware that has-many associations repeat the rows
+	// of the queried model.
+	Association string
+}
+
+// relationCol splits name into relation and column if name is "relation.column"
+// and relation is in Config.Relations.
+func relationCol(c *Config, name string) (string, string, bool) {
+	i := strings.Index(name, ".")
+	if i < 0 {
+		return "", "", false
+	}
+	for r := range c.Relations {
+		if strings.EqualFold(r, name[:i]) {
+			return strings.ToLower(r), name[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// relations returns the sorted names of the relations the query uses.
+func relations(c *Config, q *Query) []string {
+	if len(c.Relations) == 0 {
+		return nil
+	}
+	var names []string
+	if blank(q.Select) {
+		names = append(names, c.SelectableCols...)
+	} else {
+		for _, s := range q.Select {
+			names = append(names, column(c, strings.ToLower(strings.TrimSpace(s))))
+		}
+	}
+	for k := range q.WhereArgs {
+		names = append(names, column(c, strings.ToLower(strings.TrimSpace(k))))
+	}
+	obs := q.OrderBy
+	if blank(obs) {
+		obs = c.DefaultOrderBy
+	}
+	for _, o := range orderEntries(c, obs) {
+		if ob, ok, err := parseOrder(c, o); err == nil && ok {
+			names = append(names, column(c, ob.col))
+		}
+	}
+	names = append(names, c.TiebreakerCol)
+	for _, g := range q.GroupBy {
+		names = append(names, column(c, strings.ToLower(strings.TrimSpace(g))))
+	}
+	if aggs, err := aggregates(c, q); err == nil {
+		for _, a := range aggs {
+			names = append(names, a.col)
+		}
+	}
+	if q.Search != "" {
+		for _, sc := range searchCols(c) {
+			names = append(names, sc.Name)
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, n := range names {
+		if r, _, ok := relationCol(c, n); ok {
+			used[r] = true
+		}
+	}
+	var rels []string
+	for r := range used {
+		rels = append(rels, r)
+	}
+	sort.Strings(rels)
+	return rels
+}
+
+// joinSQL returns the SQL that joins relation name into the query on the
+// model c.model.
+func joinSQL(db *gorm.DB, c *Config, name string) (string, error) {
+	var rel Relation
+	for r, v := range c.Relations {
+		if strings.EqualFold(r, name) {
+			rel = v
+		}
+	}
+	if rel.Join != "" {
+		return rel.Join, nil
+	}
+	if rel.Association == "" {
+		return "", fmt.Errorf("relation %q has neither a join nor an association", name)
+	}
+	if c.model == nil {
+		return "", fmt.Errorf("relation %q needs a model to join association %q", name, rel.Association)
+	}
+	scope := db.NewScope(c.model)
+	for _, f := range scope.GetModelStruct().StructFields {
+		if f.Name != rel.Association || f.Relationship == nil {
+			continue
+		}
+		table := db.NewScope(reflect.New(indirectType(f.Struct.Type)).Interface()).TableName()
+
+		// The keys of the related table and those of the queried table.
+		var related, own []string
+		switch f.Relationship.Kind {
+		case "belongs_to":
+			related, own = f.Relationship.AssociationForeignDBNames, f.Relationship.ForeignDBNames
+		case "has_one", "has_many":
+			related, own = f.Relationship.ForeignDBNames, f.Relationship.AssociationForeignDBNames
+		default:
+			return "", fmt.Errorf("relation %q: cannot join %s association %q", name, f.Relationship.Kind, rel.Association)
+		}
+		var on bytes.Buffer
+		for i := range related {
+			pad(&on, " AND ")
+			fmt.Fprintf(&on, "%s.%s = %s.%s", name, related[i], scope.TableName(), own[i])
+		}
+		return fmt.Sprintf("JOIN %s AS %s ON %s", table, name, on.String()), nil
+	}
+	return "", fmt.Errorf("relation %q: model has no association %q", name, rel.Association)
+}
@@ -0,0 +1,315 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// RelevanceKey is a special OrderBy key that orders results by how well they
+// match Query.Search, best matches first. It may be followed by a direction like
+// any other OrderBy column ("_relevance asc" puts the worst matches first). It
+// does not need to be listed in Config.OrderableCols and it is ignored if
+// Query.Search is empty.
+const RelevanceKey = "_relevance"
+
+// maxSearchTokens is the default for Config.MaxSearchTokens.
+const maxSearchTokens = 10
+
+// SearchMode describes how a search column matches the Search term.
+type SearchMode int
+
+const (
+	// SearchContains matches the term anywhere in the column.
+	SearchContains SearchMode = iota
+	// SearchPrefix matches columns that start with the term.
+	SearchPrefix
+	// SearchExact matches columns equal to the term.
+	SearchExact
+	// SearchFullText matches the term using the database's full-text engine.
+	// Only Postgres and MySQL support it.
+	SearchFullText
+	// SearchFuzzy matches columns similar to the term, tolerating typos. On
+	// Postgres it uses the pg_trgm extension's similarity(). Elsewhere the term
+	// is matched in Go with the same trigram similarity; see
+	// Config.MaxFuzzyRows.
+	SearchFuzzy
+
+	// searchWhere matches the term using the column's LIKE clause in
+	// Config.Where. It is only used when Config.SearchCols is empty.
+	searchWhere SearchMode = -1
+)
+
+// SearchCol configures a column searched by Query.Search. The contains, prefix
+// and exact modes ignore case.
+type SearchCol struct {
+	// Name is the column name.
+	Name string
+
+	// Mode is how the column matches the Search term.
+	Mode SearchMode
+
+	// Weight weighs the column when ordering by RelevanceKey. E.g. a column of
+	// weight 2 counts twice as much as a column of weight 1. Zero means 1.
+	Weight float64
+}
+
+// searchCols returns the columns searched by Query.Search. Unless they are
+// configured in Config.SearchCols, they are the Config.Where entries that
+// contain a LIKE clause. Hidden columns are never searched.
+func searchCols(c *Config) []SearchCol {
+	var cols []SearchCol
+	if len(c.SearchCols) > 0 {
+		for _, sc := range c.SearchCols {
+			if !hidden(c, sc.Name) {
+				cols = append(cols, sc)
+			}
+		}
+		return cols
+	}
+	for _, k := range likeClauses(c) {
+		if hidden(c, k) {
+			continue
+		}
+		mode := searchWhere
+		if c.FullTextSearch {
+			mode = SearchFullText
+		}
+		cols = append(cols, SearchCol{Name: k, Mode: mode, Weight: c.SearchWeights[k]})
+	}
+	return cols
+}
+
+// searchClause returns the condition that matches the Search term against
+// column sc.
+func searchClause(c *Config, sc SearchCol) (string, error) {
+	col := colExpr(c, sc.Name)
+	switch sc.Mode {
+	case searchWhere:
+		return col + " " + c.Where[sc.Name], nil
+	case SearchContains, SearchPrefix:
+		return fmt.Sprintf("LOWER(%s) LIKE LOWER(?)", col), nil
+	case SearchExact:
+		return fmt.Sprintf("LOWER(%s) = LOWER(?)", col), nil
+	case SearchFullText:
+		switch c.dialect {
+		case "postgres":
+			return fmt.Sprintf("to_tsvector(%s) @@ plainto_tsquery(?)", col), nil
+		case "mysql":
+			return fmt.Sprintf("MATCH (%s) AGAINST (?)", col), nil
+		}
+		return "", fmt.Errorf("full-text search is not supported by dialect %q", c.dialect)
+	case SearchFuzzy:
+		if c.dialect == "postgres" {
+			return fmt.Sprintf("similarity(%s, ?) >= %s", col, strconv.FormatFloat(fuzzyThreshold(c), 'g', -1, 64)), nil
+		}
+		return "", fmt.Errorf("fuzzy search is not supported by dialect %q", c.dialect)
+	}
+	return "", fmt.Errorf("invalid search mode %d for column %q", sc.Mode, sc.Name)
+}
+
+// searchArg returns the argument bound to searchClause for column sc and search
+// term s. If token is true, s is a token of a tokenized search.
+func searchArg(sc SearchCol, s string, token bool) interface{} {
+	switch sc.Mode {
+	case searchWhere:
+		// The LIKE clause is used verbatim, so is s. Unless a token already has
+		// wildcards, it matches anywhere in the column.
+		if token && !strings.Contains(s, "%") {
+			return "%" + s + "%"
+		}
+		return s
+	case SearchContains:
+		return "%" + strings.Trim(s, "%") + "%"
+	case SearchPrefix:
+		return strings.Trim(s, "%") + "%"
+	}
+	// Exact, full-text and fuzzy matches have no use for LIKE wildcards.
+	return strings.Trim(s, "%")
+}
+
+// search builds the condition that applies Query.Search to the searched
+// columns.
+func search(c *Config, q *Query) (string, []interface{}, error) {
+	if fuzzyInMemory(c, q) {
+		// The term is matched in Go.
+		return "", nil, nil
+	}
+	cols := searchCols(c)
+	if !c.TokenizeSearch {
+		return matchAny(c, cols, q.Search, false)
+	}
+
+	include, exclude, err := searchTokens(c, strings.Trim(q.Search, "%"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var buf bytes.Buffer
+	var args []interface{}
+
+	// Every token must match at least one column.
+	for _, t := range include {
+		m, ma, err := matchAny(c, cols, t, true)
+		if err != nil {
+			return "", nil, err
+		}
+		if m == "" {
+			continue
+		}
+		pad(&buf, " AND ")
+		buf.WriteString("(")
+		buf.WriteString(m)
+		buf.WriteString(")")
+		args = append(args, ma...)
+	}
+	// And no excluded token may match any column. NULL columns don't match.
+	for _, t := range exclude {
+		for _, sc := range cols {
+			s, err := searchClause(c, sc)
+			if err != nil {
+				return "", nil, err
+			}
+			pad(&buf, " AND ")
+			fmt.Fprintf(&buf, "(%s IS NULL OR NOT (%s))", colExpr(c, sc.Name), s)
+			args = append(args, searchArg(sc, t, true))
+		}
+	}
+	return buf.String(), args, nil
+}
+
+// matchAny builds the condition that matches search term s against any of the
+// columns.
+func matchAny(c *Config, cols []SearchCol, s string, token bool) (string, []interface{}, error) {
+	var buf bytes.Buffer
+	var args []interface{}
+
+	for _, sc := range cols {
+		m, err := searchClause(c, sc)
+		if err != nil {
+			return "", nil, err
+		}
+		pad(&buf, " OR ")
+		buf.WriteString(m)
+		args = append(args, searchArg(sc, s, token))
+	}
+	return buf.String(), args, nil
+}
+
+// searchTerms returns the terms that rows are expected to match.
+func searchTerms(c *Config, q *Query) []string {
+	s := strings.Trim(q.Search, "%")
+	if !c.TokenizeSearch {
+		return []string{s}
+	}
+	include, _ := tokenize(s)
+	return include
+}
+
+// searchTokens tokenizes search term s and checks it has no more tokens than
+// Config.MaxSearchTokens allows.
+func searchTokens(c *Config, s string) (include, exclude []string, err error) {
+	include, exclude = tokenize(s)
+	max := c.MaxSearchTokens
+	if max == 0 {
+		max = maxSearchTokens
+	}
+	if n := len(include) + len(exclude); n > max {
+		return nil, nil, fmt.Errorf("search term has %d tokens, the maximum is %d", n, max)
+	}
+	return include, exclude, nil
+}
+
+// tokenize splits a search term into the tokens that must match and the tokens
+// that must not. Tokens are separated by white space. Text between double quotes
+// is a single token and a token preceded by "-" is an exclusion, so
+// `john "van der" -smith` includes "john" and "van der" and excludes "smith".
+func tokenize(s string) (include, exclude []string) {
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+		neg := false
+		if rs[i] == '-' {
+			neg = true
+			i++
+		}
+		var t []rune
+		if i < len(rs) && rs[i] == '"' {
+			i++
+			for i < len(rs) && rs[i] != '"' {
+				t = append(t, rs[i])
+				i++
+			}
+			i++ // Closing quote, if any.
+		} else {
+			for i < len(rs) && !unicode.IsSpace(rs[i]) {
+				t = append(t, rs[i])
+				i++
+			}
+		}
+		tok := strings.TrimSpace(string(t))
+		if tok == "" {
+			continue
+		}
+		if neg {
+			exclude = append(exclude, tok)
+		} else {
+			include = append(include, tok)
+		}
+	}
+	return include, exclude
+}
+
+// relevance builds the expression used to order by RelevanceKey. Full-text
+// columns use the engine's rank function and fuzzy columns their similarity.
+// Other columns score an exact match above a prefix match above a match
+// anywhere in the column. Either way, each column's score is multiplied by its
+// weight and the scores of all search terms are added up.
+func relevance(c *Config, q *Query) (string, []interface{}, error) {
+	var buf bytes.Buffer
+	var args []interface{}
+
+	if fuzzyInMemory(c, q) {
+		// Relevance is computed in Go.
+		return "", nil, nil
+	}
+	for _, term := range searchTerms(c, q) {
+		for _, sc := range searchCols(c) {
+			col := colExpr(c, sc.Name)
+			pad(&buf, " + ")
+			if sc.Mode == SearchFuzzy {
+				fmt.Fprintf(&buf, "similarity(%s, ?)", col)
+				args = append(args, term)
+			} else if sc.Mode == SearchFullText {
+				switch c.dialect {
+				case "postgres":
+					fmt.Fprintf(&buf, "ts_rank(to_tsvector(%s), plainto_tsquery(?))", col)
+				case "mysql":
+					fmt.Fprintf(&buf, "MATCH (%s) AGAINST (?)", col)
+				default:
+					return "", nil, fmt.Errorf("full-text search is not supported by dialect %q", c.dialect)
+				}
+				args = append(args, term)
+			} else {
+				fmt.Fprintf(&buf, "(CASE WHEN LOWER(%[1]s) = LOWER(?) THEN 3 WHEN LOWER(%[1]s) LIKE LOWER(?) THEN 2 WHEN LOWER(%[1]s) LIKE LOWER(?) THEN 1 ELSE 0 END)", col)
+				args = append(args, term, term+"%", "%"+term+"%")
+			}
+			if sc.Weight != 0 {
+				buf.WriteString(" * ")
+				buf.WriteString(strconv.FormatFloat(sc.Weight, 'g', -1, 64))
+			}
+		}
+	}
+	if buf.Len() == 0 {
+		return "", nil, nil
+	}
+	return "(" + buf.String() + ")", args, nil
+}
@@ -0,0 +1,83 @@
+// Copyright District Capital Inc 2019
+// All rights reserved.
+
+package paginate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// maxExportRows is the default for Config.MaxExportRows.
+const maxExportRows = 100000
+
+// Stream performs the query as described by Query, subject to the constraints
+// of Config, and calls fn with each resulting row in order, one row at a time.
+// All pages are streamed, up to Config.MaxExportRows rows: Page and PageSize
+// are ignored. If the query matches more rows, Stream returns an error after
+// streaming as many. It also stops if fn returns an error or ctx is done, and
+// returns that error.
+//
+// Rows are scanned one by one, so associations cannot be included, and
+// queries that search fuzzy columns in memory are not supported.
+func Stream[T any](ctx context.Context, db *gorm.DB, c Config, q Query, fn func(T) error) error {
+	return stream[T](ctx, db, c, q, nil, func(db *gorm.DB, rows *sql.Rows) error {
+		var row T
+		if err := db.ScanRows(rows, &row); err != nil {
+			return err
+		}
+		return fn(row)
+	})
+}
+
+// stream implements Stream for the model T. It calls cols, if any, with the
+// columns of the results before the first row, then fn to scan each row.
+func stream[T any](ctx context.Context, db *gorm.DB, c Config, q Query, cols func([]string) error, fn func(*gorm.DB, *sql.Rows) error) error {
+	if !blank(q.Include) {
+		return fmt.Errorf("query cannot include associations when streaming")
+	}
+	var model T
+	c.dialect = db.Dialect().GetName()
+	c.model = &model
+	if fuzzyInMemory(&c, &q) {
+		return fmt.Errorf("query cannot stream rows matched by a fuzzy search")
+	}
+	db, err := query(db.Model(&model), &c, &q)
+	if err != nil {
+		return err
+	}
+	max := c.MaxExportRows
+	if max == 0 {
+		max = maxExportRows
+	}
+	rows, err := db.Limit(max + 1).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if cols != nil {
+		names, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		if err := cols(names); err != nil {
+			return err
+		}
+	}
+	for n := 0; rows.Next(); n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if n == max {
+			return fmt.Errorf("query matches more than %d rows", max)
+		}
+		if err := fn(db, rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}